@@ -3,15 +3,24 @@ package doc
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"git-server/internal/conf"
+	"git-server/internal/route/repo"
+	"git-server/internal/type"
 	"github.com/gogs/git-module"
 	"github.com/unknwon/com"
 	"github.com/urfave/cli"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -21,9 +30,11 @@ var (
 		Description: "All sub-commands should only be called by Git",
 		Flags: []cli.Flag{
 			stringFlag("branch, b", "", "Protected branch"),
+			stringFlag("config, c", "", "Custom configuration file path"),
 		},
 		Subcommands: []cli.Command{
 			subcmdHookPreReceive,
+			subcmdHookPostReceive,
 		},
 	}
 
@@ -33,6 +44,13 @@ var (
 		Description: "This command should only be called by Git",
 		Action:      runHookPreReceive,
 	}
+
+	subcmdHookPostReceive = cli.Command{
+		Name:        "post-receive",
+		Usage:       "Delegate post-receive Git hook",
+		Description: "This command should only be called by Git",
+		Action:      runHookPostReceive,
+	}
 )
 
 func runHookPreReceive(c *cli.Context) error {
@@ -102,6 +120,8 @@ func runHookPreReceive(c *cli.Context) error {
 		}
 	}
 
+	checkPushQuota()
+
 	customHooksPath := filepath.Join(os.Getenv(ENV_REPO_CUSTOM_HOOKS_PATH), "pre-receive")
 	if !com.IsFile(customHooksPath) {
 		return nil
@@ -123,6 +143,86 @@ func runHookPreReceive(c *cli.Context) error {
 	}
 	return nil
 }
+func runHookPostReceive(c *cli.Context) error {
+	// Drain the input to not block Git on writing updated references.
+	_, _ = io.Copy(io.Discard, os.Stdin)
+
+	if !c.GlobalIsSet("config") {
+		return nil
+	}
+	if err := conf.InitFrom(c.GlobalString("config")); err != nil {
+		fail("Internal error", "Failed to load configuration: %v", err)
+	}
+
+	resp, err := callbackServer(git.HookPostReceive, url.Values{})
+	if err != nil {
+		fail("Internal error", "Failed to trigger post-receive tasks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		fail("Internal error", "Failed to trigger post-receive tasks: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkPushQuota asks the server whether incoming objects of the push exceed
+// the quota. Git keeps incoming objects in the quarantine directory until all
+// pre-receive checks are passed.
+func checkPushQuota() {
+	confPath := os.Getenv(ENV_CUSTOM_CONF)
+	quarantinePath := os.Getenv("GIT_QUARANTINE_PATH")
+	if confPath == "" || quarantinePath == "" {
+		return
+	}
+	if err := conf.InitFrom(confPath); err != nil {
+		fail("Internal error", "Failed to load configuration: %v", err)
+	}
+
+	var size int64
+	_ = filepath.Walk(quarantinePath, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+
+	resp, err := callbackServer(git.HookPreReceive, url.Values{"size": {strconv.FormatInt(size, 10)}})
+	if err != nil {
+		fail("Internal error", "Failed to check quota: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden {
+		var result _type.ResultType
+		_ = json.NewDecoder(resp.Body).Decode(&result)
+		fail(result.Msg, "")
+	} else if resp.StatusCode/100 != 2 {
+		fail("Internal error", "Failed to check quota: %d", resp.StatusCode)
+	}
+}
+
+// callbackServer calls back to the server for the hook of the repository in
+// the working directory, where Git runs hooks.
+func callbackServer(hook git.HookName, query url.Values) (*http.Response, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("get working directory: %v", err)
+	}
+	rel, err := filepath.Rel(conf.Repository.Root, wd)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("repository %q is not under root %q", wd, conf.Repository.Root)
+	}
+	repoLink := strings.TrimSuffix(filepath.ToSlash(rel), ".git")
+
+	query.Set("secret", repo.HookSecret(repoLink))
+	callbackURL := fmt.Sprintf("%s%s/hooks/%s?%s", conf.Server.ExternalURL, repoLink, hook, query.Encode())
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	return client.Post(callbackURL, "application/json", nil)
+}
+
 func fail(userMessage, errMessage string, args ...any) {
 	_, _ = fmt.Fprintln(os.Stderr, "Gogs:", userMessage)
 
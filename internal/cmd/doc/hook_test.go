@@ -0,0 +1,72 @@
+package doc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/route/repo"
+)
+
+func TestCallbackServer(t *testing.T) {
+	oldRepository, oldServer, oldSecurity := conf.Repository, conf.Server, conf.Security
+	t.Cleanup(func() {
+		conf.Repository, conf.Server, conf.Security = oldRepository, oldServer, oldSecurity
+	})
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Post("/:username/:reponame/hooks/pre-receive", repo.HookPreReceive)
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	// The configuration ships a relative data path and no secret key.
+	confDir := t.TempDir()
+	root := filepath.Join(t.TempDir(), "repositories")
+	confPath := filepath.Join(confDir, "app.ini")
+	require.NoError(t, os.WriteFile(confPath, []byte(fmt.Sprintf(`[repository]
+ROOT = %s
+[server]
+EXTERNAL_URL = %s/
+APP_DATA_PATH = data
+[security]
+SECRET_KEY =
+`, filepath.ToSlash(root), srv.URL)), 0644))
+	repoPath := filepath.Join(root, "alice", "repo.git")
+	runGit(t, t.TempDir(), "init", "--bare", repoPath)
+
+	// The server and hooks run in different directories.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, conf.InitFrom(confPath))
+	assert.FileExists(t, filepath.Join(confDir, "data", "secret_key"))
+	serverKey := conf.Security.SecretKey
+
+	// Hooks load the configuration in the repository and must derive the same
+	// secret as the server.
+	require.NoError(t, os.Chdir(repoPath))
+	conf.Security.SecretKey = ""
+	require.NoError(t, conf.InitFrom(confPath))
+	assert.Equal(t, serverKey, conf.Security.SecretKey)
+	resp, err := callbackServer(git.HookPreReceive, url.Values{"size": {"0"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoDirExists(t, filepath.Join(repoPath, "data"))
+}
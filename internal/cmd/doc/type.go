@@ -9,4 +9,5 @@ const (
 	ENV_REPO_ID                = "GOGS_REPO_ID"
 	ENV_REPO_NAME              = "GOGS_REPO_NAME"
 	ENV_REPO_CUSTOM_HOOKS_PATH = "GOGS_REPO_CUSTOM_HOOKS_PATH"
+	ENV_CUSTOM_CONF            = "GOGS_CUSTOM_CONF"
 )
@@ -29,62 +29,40 @@ func runWeb(c *cli.Context) error {
 		os.Exit(-1)
 	}
 	auth.Init()
+	repo.InitRepoMeta()
+	repo.InitHooks()
+	repo.InitSyncMirrors()
+	repo.InitPurgeTrash()
+	repo.InitHousekeeping()
+	repo.InitCleanup()
+	repo.InitIndexing()
 	fmt.Println(conf.AppPath())
 	m := macaron.Classic()
 	bindIgnErr := binding.BindIgnErr
 	m.Use(macaron.Renderer())
 	m.Group("", func() {
-		m.Group("/:username/:reponame", func() {
-			m.Get("", repo.Home)
-			m.Get("/src/*", repo.Home)
-			m.Get("/raw/*", repo.SingleDownload)
-			m.Get("/commits/*", repo.RefCommits)
-			m.Post("/createBranch", bindIgnErr(form.CreateBranch{}), repo.CreateBranch)
-			m.Group("", func() {
-				m.Post("/_edit/*", bindIgnErr(form.EditRepoFile{}), repo.EditFilePost)
-				m.Post("/_upload/*", bindIgnErr(form.UploadRepoFile{}), repo.UploadFilePost)
-				m.Post("/upload-file", repo.UploadFileToServer)
-				m.Post("/upload-remove", bindIgnErr(form.RemoveUploadFile{}), repo.RemoveUploadFileFromServer)
-				m.Post("/_delete/*", bindIgnErr(form.DeleteRepoFile{}), repo.DeleteFilePost)
-			})
-			m.Group("", func() {
-				m.Get("/commit/:sha([a-f0-9]{7,40})$", repo.Diff)
-				m.Get("/compare/:before\\.\\.\\.:after", repo.CompareAndPullRequest)
-				m.Post("/compare/:before\\.\\.\\.:after", repo.CompareAndPullRequestPost)
-			})
-			m.Group("/branches", func() {
-				m.Get("", repo.Branches)
-				m.Get("/all", repo.AllBranches)
-			})
-			m.Group("/pulls", func() {
-				m.Post("/commits", bindIgnErr(form.PullRequest{}), repo.ViewPullCommits)
-				m.Post("/merge", bindIgnErr(form.MergePullRequest{}), repo.MergePullRequest)
-				m.Post("/files", bindIgnErr(form.PullRequest{}), repo.ViewPullFiles)
-				m.Post("", bindIgnErr(form.PullRequest{}), repo.PrepareViewPullInfo)
-				m.Post("/mm", bindIgnErr(form.MergePullRequest{}), repo.MM)
-			})
-			m.Group("/settings", func() {
-				m.Group("/branches", func() {
-					m.Get("", repo.SettingsBranches)
-					m.Get("/default_branch", repo.UpdateDefaultBranch)
-					m.Combo("/*").Get(repo.SettingsProtectedBranch).
-						Post(bindIgnErr(form.ProtectedBranch{}), repo.SettingsProtectedBranchPost)
-				})
-			})
-		}, context.RepoAssignment(), context.RepoRef())
+		repoRoutes(m)
 		m.Group("/repo", func() {
 			m.Post("/create", bindIgnErr(form.Repo{}), repo.CreatePost)
+			m.Get("/create/options", repo.CreateOptions)
+			m.Post("/migrate", bindIgnErr(form.MigrateRepo{}), repo.MigratePost)
 			m.Post("/delete", bindIgnErr(form.Repo{}), repo.DeletePost)
 		})
 		m.Group("/repos", func() {
 			m.Post("", bindIgnErr(form.ListRepo{}), repo.ListRepo)
 			m.Delete("", bindIgnErr(form.Repo{}), repo.DeleteRepo)
+			m.Get("/trash", repo.ListDeletedRepos)
+			m.Get("/quota", repo.QuotaUsage)
+			m.Get("/search/code", repo.SearchCode)
+			m.Post("/trash/:id/restore", repo.RestoreRepoPost)
 		})
+		m.Post("/:username/:reponame/hooks/pre-receive", repo.HookPreReceive)
+		m.Post("/:username/:reponame/hooks/post-receive", repo.HookPostReceive)
 		// ***************************
 		// ----- HTTP Git routes -----
 		// ***************************
 		m.Group("/:username/:reponame", func() {
-			m.Route("/*", "GET,POST,OPTIONS", repo.HTTPContexter(), repo.HTTP)
+			m.Route("/*", "GET,POST,OPTIONS", repo.RedirectRenamed, repo.HTTPContexter(), repo.HTTP)
 		})
 	},
 		context.Contexter(),
@@ -92,3 +70,85 @@ func runWeb(c *cli.Context) error {
 	m.Run()
 	return nil
 }
+
+// repoRoutes registers routes of repositories, all of which require read
+// access to the repository and those changing it require write access. Forks
+// require access to the owner of the fork instead.
+func repoRoutes(m *macaron.Macaron) {
+	bindIgnErr := binding.BindIgnErr
+	m.Group("/:username/:reponame", func() {
+		m.Get("", repo.Home)
+		m.Get("/src/*", repo.Home)
+		m.Get("/raw/*", repo.SingleDownload)
+		m.Get("/commits/*", repo.RefCommits)
+		m.Get("/file/*", repo.ViewFile)
+		m.Get("/blame/*", repo.Blame)
+		m.Post("/markdown/*", bindIgnErr(form.Markdown{}), repo.RenderMarkdown)
+		m.Get("/graph/*", repo.CommitGraph)
+		m.Get("/compare", repo.Compare)
+		m.Get("/compare/file-list", repo.CompareDiffFiles)
+		m.Get("/compare/file-diff", repo.CompareFileDiff)
+		m.Post("/createBranch", repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror, bindIgnErr(form.CreateBranch{}), repo.CreateBranch)
+		m.Post("/fork", bindIgnErr(form.ForkRepo{}), repo.ForkPost)
+		m.Get("/forks", repo.ListForks)
+		m.Group("/mirror", func() {
+			m.Get("", repo.MirrorStatus)
+			m.Post("/sync", repo.MustHaveWriteAccess, repo.MirrorSyncPost)
+		})
+		m.Combo("/housekeeping").Get(repo.HousekeepingStatus).
+			Post(repo.MustHaveWriteAccess, repo.HousekeepingPost)
+		m.Group("/push_mirrors", func() {
+			m.Combo("").Get(repo.PushMirrors).
+				Post(repo.MustHaveWriteAccess, bindIgnErr(form.PushMirror{}), repo.AddPushMirrorPost)
+			m.Delete("/:id", repo.MustHaveWriteAccess, repo.DeletePushMirrorPost)
+			m.Post("/:id/sync", repo.MustHaveWriteAccess, repo.SyncPushMirrorPost)
+		})
+		m.Group("", func() {
+			m.Post("/_edit/*", bindIgnErr(form.EditRepoFile{}), repo.EditFilePost)
+			m.Post("/_upload/*", bindIgnErr(form.UploadRepoFile{}), repo.UploadFilePost)
+			m.Post("/upload-file", repo.UploadFileToServer)
+			m.Post("/upload-remove", bindIgnErr(form.RemoveUploadFile{}), repo.RemoveUploadFileFromServer)
+			m.Post("/_delete/*", bindIgnErr(form.DeleteRepoFile{}), repo.DeleteFilePost)
+		}, repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror)
+		m.Group("", func() {
+			m.Get("/commit/:sha([a-f0-9]{7,40})$", repo.Diff)
+			m.Get("/commit/:sha([a-f0-9]{7,40})\\.:ext(diff|patch)$", repo.RawCommitDiff)
+			m.Get("/commit/:sha([a-f0-9]{7,40})/file-list", repo.CommitDiffFiles)
+			m.Get("/commit/:sha([a-f0-9]{7,40})/file-diff", repo.CommitFileDiff)
+			m.Get("/compare/:before\\.\\.\\.:after", repo.CompareAndPullRequest)
+			m.Post("/compare/:before\\.\\.\\.:after", repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror, repo.CompareAndPullRequestPost)
+		})
+		m.Group("/branches", func() {
+			m.Get("", repo.Branches)
+			m.Get("/all", repo.AllBranches)
+			m.Get("/deleted", repo.ListDeletedBranches)
+			m.Post("/deleted/:id/restore", repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror, repo.RestoreBranchPost)
+			m.Post("/delete-merged", repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror, repo.DeleteMergedBranchesPost)
+			m.Delete("/*", repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror, repo.DeleteBranchPost)
+			m.Post("/*", repo.MustBeBranchRename, repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror, bindIgnErr(form.RenameBranch{}), repo.RenameBranchPost)
+		})
+		m.Group("/pulls", func() {
+			m.Post("/commits", bindIgnErr(form.PullRequest{}), repo.ViewPullCommits)
+			m.Post("/merge", repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.MustBeNotMirror, bindIgnErr(form.MergePullRequest{}), repo.MergePullRequest)
+			m.Post("/files", bindIgnErr(form.PullRequest{}), repo.ViewPullFiles)
+			m.Post("/files\\.:ext(diff|patch)$", bindIgnErr(form.PullRequest{}), repo.RawPullDiff)
+			m.Post("/file-list", bindIgnErr(form.PullRequest{}), repo.PullDiffFiles)
+			m.Post("/file-diff", bindIgnErr(form.PullRequest{}), repo.PullFileDiff)
+			m.Post("", bindIgnErr(form.PullRequest{}), repo.PrepareViewPullInfo)
+			m.Post("/mm", repo.MustHaveWriteAccess, bindIgnErr(form.MergePullRequest{}), repo.MM)
+		})
+		m.Group("/settings", func() {
+			m.Combo("").Get(repo.Settings).
+				Post(repo.MustHaveWriteAccess, repo.MustBeNotArchived, bindIgnErr(form.RepoSetting{}), repo.SettingsPost)
+			m.Post("/archive", repo.MustHaveWriteAccess, repo.ArchivePost)
+			m.Post("/unarchive", repo.MustHaveWriteAccess, repo.UnarchivePost)
+			m.Post("/transfer", repo.MustHaveWriteAccess, bindIgnErr(form.TransferRepo{}), repo.TransferPost)
+			m.Group("/branches", func() {
+				m.Get("", repo.SettingsBranches)
+				m.Get("/default_branch", repo.MustHaveWriteAccess, repo.MustBeNotArchived, repo.UpdateDefaultBranch)
+				m.Combo("/*").Get(repo.SettingsProtectedBranch).
+					Post(repo.MustHaveWriteAccess, repo.MustBeNotArchived, bindIgnErr(form.ProtectedBranch{}), repo.SettingsProtectedBranchPost)
+			})
+		})
+	}, repo.RedirectRenamed, context.RepoAssignment(), repo.MustHaveReadAccess, context.RepoRef())
+}
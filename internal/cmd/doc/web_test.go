@@ -0,0 +1,199 @@
+package doc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/route/repo"
+)
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+		"GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "git %v: %s", args, out)
+}
+
+// newTestRepoRoutes returns routes of repositories with the repository
+// "alice/repo" that has a commit on the "master" branch.
+func newTestRepoRoutes(t *testing.T) *macaron.Macaron {
+	oldRepository := conf.Repository
+	conf.Repository.Root = filepath.Join(t.TempDir(), "repositories")
+	conf.Repository.LocalPath = filepath.Join(t.TempDir(), "local")
+	t.Cleanup(func() {
+		conf.Repository = oldRepository
+	})
+
+	repoPath := filepath.Join(conf.Repository.Root, "alice", "repo.git")
+	runGit(t, t.TempDir(), "init", "--bare", repoPath)
+	workDir := t.TempDir()
+	runGit(t, workDir, "init", "-b", "master")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "README.md"), []byte("# test\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "initial commit")
+	runGit(t, workDir, "push", repoPath, "master")
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Group("", func() {
+		repoRoutes(m)
+	}, context.Contexter())
+	return m
+}
+
+func TestRepoRoutes_PrivateRepository(t *testing.T) {
+	// A repository without metadata is private.
+	m := newTestRepoRoutes(t)
+
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{"GET", ""},
+		{"GET", "/src/master/README.md"},
+		{"GET", "/raw/master/README.md"},
+		{"GET", "/commits/master"},
+		{"GET", "/file/master/README.md"},
+		{"GET", "/blame/master/README.md"},
+		{"POST", "/markdown/master/README.md"},
+		{"GET", "/graph/master"},
+		{"GET", "/compare"},
+		{"GET", "/compare/file-list"},
+		{"GET", "/compare/file-diff"},
+		{"POST", "/createBranch"},
+		{"POST", "/fork"},
+		{"GET", "/forks"},
+		{"GET", "/mirror"},
+		{"POST", "/mirror/sync"},
+		{"GET", "/housekeeping"},
+		{"POST", "/housekeeping"},
+		{"GET", "/push_mirrors"},
+		{"POST", "/push_mirrors"},
+		{"DELETE", "/push_mirrors/1"},
+		{"POST", "/push_mirrors/1/sync"},
+		{"POST", "/_edit/master/README.md"},
+		{"POST", "/_upload/master/"},
+		{"POST", "/upload-file"},
+		{"POST", "/upload-remove"},
+		{"POST", "/_delete/master/README.md"},
+		{"GET", "/commit/" + sha},
+		{"GET", "/commit/" + sha + ".diff"},
+		{"GET", "/commit/" + sha + ".patch"},
+		{"GET", "/commit/" + sha + "/file-list"},
+		{"GET", "/commit/" + sha + "/file-diff"},
+		{"GET", "/compare/master...master"},
+		{"POST", "/compare/master...master"},
+		{"GET", "/branches"},
+		{"GET", "/branches/all"},
+		{"GET", "/branches/deleted"},
+		{"POST", "/branches/deleted/1/restore"},
+		{"POST", "/branches/delete-merged"},
+		{"DELETE", "/branches/master"},
+		{"POST", "/branches/master/rename"},
+		{"POST", "/pulls"},
+		{"POST", "/pulls/commits"},
+		{"POST", "/pulls/merge"},
+		{"POST", "/pulls/files"},
+		{"POST", "/pulls/files.diff"},
+		{"POST", "/pulls/file-list"},
+		{"POST", "/pulls/file-diff"},
+		{"POST", "/pulls/mm"},
+		{"GET", "/settings"},
+		{"POST", "/settings"},
+		{"POST", "/settings/archive"},
+		{"POST", "/settings/unarchive"},
+		{"POST", "/settings/transfer"},
+		{"GET", "/settings/branches"},
+		{"GET", "/settings/branches/default_branch"},
+		{"GET", "/settings/branches/master"},
+		{"POST", "/settings/branches/master"},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			req, err := http.NewRequest(route.method, "/alice/repo"+route.path, nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusUnauthorized, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+func TestRepoRoutes_PublicRepository(t *testing.T) {
+	m := newTestRepoRoutes(t)
+	require.NoError(t, repo.UpdateRepoMeta("alice/repo", func(meta *repo.RepoMeta) {
+		meta.IsPrivate = false
+	}))
+
+	routes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/createBranch", ""},
+		{"POST", "/fork", `{"Code": "bob"}`},
+		{"POST", "/mirror/sync", ""},
+		{"POST", "/housekeeping", ""},
+		{"POST", "/push_mirrors", ""},
+		{"DELETE", "/push_mirrors/1", ""},
+		{"POST", "/push_mirrors/1/sync", ""},
+		{"POST", "/_edit/master/README.md", ""},
+		{"POST", "/_upload/master/", ""},
+		{"POST", "/upload-file", ""},
+		{"POST", "/upload-remove", ""},
+		{"POST", "/_delete/master/README.md", ""},
+		{"POST", "/compare/master...master", ""},
+		{"POST", "/branches/deleted/1/restore", ""},
+		{"POST", "/branches/delete-merged", ""},
+		{"DELETE", "/branches/master", ""},
+		{"POST", "/branches/master/rename", ""},
+		{"POST", "/pulls/merge", ""},
+		{"POST", "/pulls/mm", ""},
+		{"POST", "/settings", ""},
+		{"POST", "/settings/archive", ""},
+		{"POST", "/settings/unarchive", ""},
+		{"POST", "/settings/transfer", ""},
+		{"GET", "/settings/branches/default_branch?branch=master", ""},
+		{"POST", "/settings/branches/master", ""},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			req, err := http.NewRequest(route.method, "/alice/repo"+route.path, strings.NewReader(route.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusUnauthorized, resp.Code, resp.Body.String())
+		})
+	}
+
+	// Reads are anonymous.
+	req, err := http.NewRequest("GET", "/alice/repo/file/master/README.md", nil)
+	require.NoError(t, err)
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	// Only renames are posted to branches.
+	req, err = http.NewRequest("POST", "/alice/repo/branches/master", nil)
+	require.NoError(t, err)
+	resp = httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code, resp.Body.String())
+}
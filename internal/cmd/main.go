@@ -16,6 +16,6 @@ func main() {
 		cmd.Hook,
 	}
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal("Failed to start application: %v", err)
+		log.Fatalf("Failed to start application: %v", err)
 	}
 }
@@ -0,0 +1,65 @@
+package conf
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Kinds of files that can be used to initialize a repository. Files under the
+// directory of the same name in "<custom>/conf" take precedence over built-in ones.
+const (
+	AssetGitignore = "gitignore"
+	AssetLicense   = "license"
+	AssetReadme    = "readme"
+)
+
+// AssetNames returns sorted names of built-in and custom files of given kind.
+func AssetNames(kind string) []string {
+	seen := make(map[string]bool)
+	if entries, err := fs.ReadDir(assets, path.Join("assets", kind)); err == nil {
+		for _, e := range entries {
+			seen[e.Name()] = true
+		}
+	}
+	if entries, err := os.ReadDir(filepath.Join(CustomDir(), "conf", kind)); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() {
+				seen[e.Name()] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Asset returns content of the file by given kind and name, the custom one is
+// preferred if exists.
+func Asset(kind, name string) ([]byte, error) {
+	if name == "" || name != path.Base(name) || name == "." || name == ".." {
+		return nil, errors.Errorf("invalid %s name %q", kind, name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(CustomDir(), "conf", kind, name))
+	if err == nil {
+		return data, nil
+	}
+	data, err = assets.ReadFile(path.Join("assets", kind, name))
+	if err != nil {
+		return nil, errors.Errorf("%s %q does not exist", kind, name)
+	}
+	return data, nil
+}
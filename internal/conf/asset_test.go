@@ -0,0 +1,22 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsset(t *testing.T) {
+	assert.Contains(t, AssetNames(AssetLicense), "MIT License")
+	assert.Contains(t, AssetNames(AssetGitignore), "Go")
+
+	data, err := Asset(AssetReadme, "Default")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "{Name}")
+
+	_, err = Asset(AssetLicense, "../readme/Default")
+	assert.Error(t, err)
+	_, err = Asset(AssetLicense, "Unknown")
+	assert.Error(t, err)
+}
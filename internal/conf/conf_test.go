@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestName(t *testing.T) {
@@ -15,3 +19,23 @@ func TestName(t *testing.T) {
 	}
 	fmt.Println(Auth)
 }
+
+func TestLoadSecretKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "data", "secret_key")
+
+	key, err := loadSecretKey(keyPath)
+	require.NoError(t, err)
+	assert.Len(t, key, 64)
+	fi, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// The same key is used after restarts.
+	again, err := loadSecretKey(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	require.NoError(t, os.WriteFile(keyPath, nil, 0600))
+	_, err = loadSecretKey(keyPath)
+	assert.Error(t, err)
+}
@@ -5,15 +5,30 @@
 package conf
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/pkg/errors"
 	"gopkg.in/ini.v1"
 )
 
 func Init() error {
-	inidata, err := ini.Load("app.ini")
+	return InitFrom("app.ini")
+}
+
+// InitFrom loads configuration from the file in given path.
+func InitFrom(confPath string) error {
+	inidata, err := ini.Load(confPath)
 	if err != nil {
 		return errors.Wrap(err, "Fail to read app.ini")
 	}
+	CustomConf, err = filepath.Abs(confPath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get absolute path of configuration")
+	}
 
 	if err = inidata.Section("repository").MapTo(&Repository); err != nil {
 		return errors.Wrap(err, "mapping git section")
@@ -22,6 +37,11 @@ func Init() error {
 	if err = inidata.Section("server").MapTo(&Server); err != nil {
 		return errors.Wrap(err, "mapping server section")
 	}
+	// Hooks load the configuration in directories of repositories, so relative
+	// paths are resolved against the configuration file instead.
+	if !filepath.IsAbs(Server.AppDataPath) {
+		Server.AppDataPath = filepath.Join(filepath.Dir(CustomConf), Server.AppDataPath)
+	}
 
 	if err = inidata.Section("auth").MapTo(&Auth); err != nil {
 		return errors.Wrap(err, "mapping auth section")
@@ -30,19 +50,87 @@ func Init() error {
 	if err = inidata.Section("git").MapTo(&Git); err != nil {
 		return errors.Wrap(err, "mapping auth section")
 	}
-	CustomConf, err = CurDir()
-	if err != nil {
-		errors.Wrap(err, "Failed to get working directory")
+
+	if err = inidata.Section("mirror").MapTo(&Mirror); err != nil {
+		return errors.Wrap(err, "mapping mirror section")
+	}
+
+	if err = inidata.Section("security").MapTo(&Security); err != nil {
+		return errors.Wrap(err, "mapping security section")
+	}
+	if Security.SecretKey == "" {
+		Security.SecretKey, err = loadSecretKey(filepath.Join(Server.AppDataPath, "secret_key"))
+		if err != nil {
+			return errors.Wrap(err, "load secret key")
+		}
+	}
+
+	if err = inidata.Section("housekeeping").MapTo(&Housekeeping); err != nil {
+		return errors.Wrap(err, "mapping housekeeping section")
+	}
+
+	if err = inidata.Section("search").MapTo(&Search); err != nil {
+		return errors.Wrap(err, "mapping search section")
+	}
+
+	if err = inidata.Section("view").MapTo(&View); err != nil {
+		return errors.Wrap(err, "mapping view section")
+	}
+
+	if err = inidata.Section("quota").MapTo(&Quota); err != nil {
+		return errors.Wrap(err, "mapping quota section")
+	}
+	Quota.Owners = make(map[string]int64)
+	for _, key := range inidata.Section("quota.owners").Keys() {
+		limit, err := key.Int64()
+		if err != nil {
+			return errors.Wrapf(err, "parse quota of owner %q", key.Name())
+		}
+		Quota.Owners[key.Name()] = limit
 	}
 	return nil
 }
 
+// loadSecretKey returns the secret key saved in the file, or generates and
+// saves a random one if the file does not exist.
+func loadSecretKey(keyPath string) (string, error) {
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		if key := strings.TrimSpace(string(data)); key != "" {
+			return key, nil
+		}
+		return "", errors.Errorf("secret key file %q is empty", keyPath)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "generate secret key")
+	}
+	key := hex.EncodeToString(buf)
+	if err = os.MkdirAll(filepath.Dir(keyPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(keyPath, []byte(key+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
 var (
 	CustomConf string
 	Auth       AuthOpts
 	Server     ServerOpts
 	Repository RepositoryOpts
 	Git        GitOpts
+	Mirror     MirrorOpts
+	Security   SecurityOpts
+	Quota      QuotaOpts
+
+	Housekeeping HousekeepingOpts
+	Search       SearchOpts
+	View         ViewOpts
 )
 
 type AuthOpts struct {
@@ -62,6 +150,20 @@ type RepositoryOpts struct {
 	ANSICharset   string `ini:"ANSI_CHARSET"`
 	ScriptType    string `ini:"SCRIPT_TYPE"`
 	BashPath      string `ini:"BASH_PATH"`
+
+	EnableLocalPathMigration bool `ini:"ENABLE_LOCAL_PATH_MIGRATION"`
+	// Days to keep redirecting old URLs of renamed or transferred repositories.
+	RedirectGracePeriod int `ini:"REDIRECT_GRACE_PERIOD"`
+	// Days to keep deleted repositories in trash, zero to keep until purged manually.
+	TrashRetention int `ini:"TRASH_RETENTION"`
+	// Days to keep deleted branches restorable, zero to keep forever.
+	DeletedBranchRetention int `ini:"DELETED_BRANCH_RETENTION"`
+	// Hours before an unused local copy is removed, zero to keep until the disk budget is exceeded.
+	LocalCopyMaxIdle int `ini:"LOCAL_COPY_MAX_IDLE"`
+	// MiB of disk for all local copies, least recently used ones are removed beyond it, zero for unlimited.
+	LocalCopyMaxSize int64 `ini:"LOCAL_COPY_MAX_SIZE"`
+	// Hours before an uploaded file that is never committed is removed.
+	UploadMaxAge int `ini:"UPLOAD_MAX_AGE"`
 }
 
 type GitOpts struct {
@@ -69,12 +171,66 @@ type GitOpts struct {
 	MaxDiffFiles         int `ini:"MAX_GIT_DIFF_FILES"`
 	MaxDiffLines         int `ini:"MAX_GIT_DIFF_LINES"`
 	MaxDiffLineChars     int `ini:"MAX_GIT_DIFF_LINE_CHARACTERS"`
-	Timeout              struct {
+	// Caps of the limits above which requests may raise them up to.
+	MaxDiffFilesCap     int `ini:"MAX_GIT_DIFF_FILES_CAP"`
+	MaxDiffLinesCap     int `ini:"MAX_GIT_DIFF_LINES_CAP"`
+	MaxDiffLineCharsCap int `ini:"MAX_GIT_DIFF_LINE_CHARACTERS_CAP"`
+	Timeout             struct {
 		Migrate int `ini:"MIGRATE"`
 		Mirror  int `ini:"MIRROR"`
 		Clone   int `ini:"CLONE"`
 		Pull    int `ini:"PULL"`
 		Diff    int `ini:"DIFF"`
 		GC      int `ini:"GC"`
+		Blame   int `ini:"BLAME"`
 	} `ini:"git.timeout"`
 }
+
+type MirrorOpts struct {
+	DefaultInterval int `ini:"DEFAULT_INTERVAL"` // In hours
+}
+
+type SecurityOpts struct {
+	SecretKey string `ini:"SECRET_KEY"`
+}
+
+type HousekeepingOpts struct {
+	// Minutes between each check for repositories to be maintained, zero to disable.
+	Interval int `ini:"INTERVAL"`
+	// Hours between each fsck of a repository, zero to disable.
+	FsckInterval int `ini:"FSCK_INTERVAL"`
+}
+
+type SearchOpts struct {
+	// Max size in KiB of a file to be indexed for code search.
+	MaxFileSize int64 `ini:"MAX_FILE_SIZE"`
+	// Max number of matched lines returned by a search.
+	MaxResults int `ini:"MAX_RESULTS"`
+	// Max number of indexes kept in memory, least recently used ones are dropped
+	// beyond it, zero to load indexes on every search.
+	MaxCachedIndexes int `ini:"MAX_CACHED_INDEXES"`
+	// Max number of files of a repository read by a search, zero for unlimited.
+	MaxSearchedFiles int `ini:"MAX_SEARCHED_FILES"`
+}
+
+type ViewOpts struct {
+	// Max number of lines of a file returned by a single request, zero for unlimited.
+	MaxLines int `ini:"MAX_LINES"`
+	// Max size in KiB of a file to be returned with its encoding detected, lines
+	// of larger files are returned as is, zero for unlimited.
+	MaxFileSize int64 `ini:"MAX_FILE_SIZE"`
+	// Max size in KiB of a file to be syntax highlighted, zero for unlimited.
+	MaxHighlightSize int64 `ini:"MAX_HIGHLIGHT_SIZE"`
+	// Max size in KiB of a README to be rendered in directory listings, zero for unlimited.
+	MaxReadmeSize int64 `ini:"MAX_README_SIZE"`
+}
+
+// QuotaOpts contains limits of disk usage in MiB, zero means unlimited.
+type QuotaOpts struct {
+	OwnerLimit int64 `ini:"OWNER_LIMIT"`
+	RepoLimit  int64 `ini:"REPO_LIMIT"`
+	// Seconds to cache the disk usage of a repository.
+	CacheTTL int `ini:"CACHE_TTL"`
+	// Limits of specific owners that override OwnerLimit.
+	Owners map[string]int64 `ini:"-"`
+}
@@ -6,10 +6,75 @@ import (
 )
 
 type Repo struct {
+	Code          string
+	RepoName      string
+	Description   string
+	Website       string
+	Private       *bool // Defaults to true
+	DefaultBranch string
+	AutoInit      bool
+	Readme        string
+	Gitignores    string // Comma separated
+	License       string
+	TemplateRepo  string // "<owner>/<name>"
+}
+
+// RepoSetting contains settings to update, where nil fields are not sent and
+// left unchanged.
+type RepoSetting struct {
+	Description *string
+	Website     *string
+	Private     *bool
+}
+
+func (f *RepoSetting) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type TransferRepo struct {
+	NewOwner string
+	NewName  string
+}
+
+func (f *TransferRepo) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type ForkRepo struct {
 	Code     string
 	RepoName string
 }
 
+func (f *ForkRepo) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type MigrateRepo struct {
+	CloneAddr    string
+	AuthUsername string
+	AuthPassword string
+	Code         string
+	RepoName     string
+	Mirror       bool
+	Interval     int // In hours
+}
+
+func (f *MigrateRepo) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type PushMirror struct {
+	Address      string
+	AuthUsername string
+	AuthPassword string
+	Interval     int // In hours
+	SyncOnPush   bool
+}
+
+func (f *PushMirror) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
 type Upload struct {
 	UUID string
 	Name string
@@ -103,6 +168,24 @@ func (f *ProtectedBranch) Validate(ctx *macaron.Context, errs binding.Errors) bi
 	return validate(errs, ctx.Data, f, ctx.Locale)
 }
 
+type RenameBranch struct {
+	NewName string
+	// Open pull requests of the repository to be retargeted.
+	Pulls []PullRequest
+}
+
+func (f *RenameBranch) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
+type Markdown struct {
+	Text string
+}
+
+func (f *Markdown) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
+	return validate(errs, ctx.Data, f, ctx.Locale)
+}
+
 type PullRequest struct {
 	IssueId       int
 	UserName      string
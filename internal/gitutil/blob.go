@@ -0,0 +1,24 @@
+package gitutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/gogs/git-module"
+)
+
+// BlobReader returns a reader of the content of the blob streamed from Git,
+// closing the reader before the end stops Git from reading the rest.
+func BlobReader(blob *git.Blob) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		stderr := new(bytes.Buffer)
+		err := blob.Pipeline(pw, stderr)
+		if err != nil {
+			err = fmt.Errorf("read blob: %v - %s", err, stderr)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
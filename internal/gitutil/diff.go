@@ -7,10 +7,17 @@ package gitutil
 import (
 	"bytes"
 	"fmt"
-	"html"
-	"html/template"
+	"image"
+	// Decoders of image dimensions.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"path"
+	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
 	"golang.org/x/net/html/charset"
@@ -21,28 +28,40 @@ import (
 	"git-server/internal/tool"
 )
 
+// InlineRange is a range of bytes in the content of a diff line, which is
+// changed compared to its paired line, i.e. Content[Start:End].
+type InlineRange struct {
+	Start int
+	End   int
+}
+
+// DiffLine is a wrapper to git.DiffLine with inline highlights.
+type DiffLine struct {
+	*git.DiffLine
+	// Ranges of changes compared to the paired line, set by Diff.HighlightInline.
+	Highlights []InlineRange `json:",omitempty"`
+}
+
 // DiffSection is a wrapper to git.DiffSection with helper methods.
 type DiffSection struct {
 	*git.DiffSection
+	Lines []*DiffLine
 
 	initOnce sync.Once
 	dmp      *diffmatchpatch.DiffMatchPatch
 }
 
-// ComputedInlineDiffFor computes inline diff for the given line.
-func (s *DiffSection) ComputedInlineDiffFor(line *git.DiffLine) template.HTML {
-	fallback := template.HTML(html.EscapeString(line.Content))
-	//if conf.Git.DisableDiffHighlight {
-	//	return fallback
-	//}
-
+// ComputedInlineRangesFor computes ranges of changes of the added or deleted
+// line compared to its paired line, by characters or by words if wordLevel.
+// It returns nil if the line is not paired.
+func (s *DiffSection) ComputedInlineRangesFor(line *git.DiffLine, wordLevel bool) []InlineRange {
 	// Find equivalent diff line, ignore when not found.
 	var diff1, diff2 string
 	switch line.Type {
 	case git.DiffLineAdd:
 		compareLine := s.Line(git.DiffLineDelete, line.RightLine)
 		if compareLine == nil {
-			return fallback
+			return nil
 		}
 
 		diff1 = compareLine.Content
@@ -51,14 +70,14 @@ func (s *DiffSection) ComputedInlineDiffFor(line *git.DiffLine) template.HTML {
 	case git.DiffLineDelete:
 		compareLine := s.Line(git.DiffLineAdd, line.LeftLine)
 		if compareLine == nil {
-			return fallback
+			return nil
 		}
 
 		diff1 = line.Content
 		diff2 = compareLine.Content
 
 	default:
-		return fallback
+		return nil
 	}
 
 	s.initOnce.Do(func() {
@@ -66,51 +85,145 @@ func (s *DiffSection) ComputedInlineDiffFor(line *git.DiffLine) template.HTML {
 		s.dmp.DiffEditCost = 100
 	})
 
-	diffs := s.dmp.DiffMain(diff1[1:], diff2[1:], true)
-	diffs = s.dmp.DiffCleanupEfficiency(diffs)
+	// Signs are cut for inline diff, so ranges start from 1.
+	var ops []inlineOp
+	if wordLevel {
+		ops = s.diffWords(diff1[1:], diff2[1:])
+	} else {
+		diffs := s.dmp.DiffMain(diff1[1:], diff2[1:], true)
+		diffs = s.dmp.DiffCleanupEfficiency(diffs)
+		for _, d := range diffs {
+			ops = append(ops, inlineOp{typ: d.Type, size: len(d.Text)})
+		}
+	}
+	return opsToRanges(ops, line.Type)
+}
 
-	return diffsToHTML(diffs, line.Type)
+// inlineOp is an operation of an inline diff on given number of bytes.
+type inlineOp struct {
+	typ  diffmatchpatch.Operation
+	size int
 }
 
-func diffsToHTML(diffs []diffmatchpatch.Diff, lineType git.DiffLineType) template.HTML {
-	buf := bytes.NewBuffer(nil)
+// diffWords diffs the texts word by word, where a word is a run of letters,
+// digits and underscores, or any other single character.
+func (s *DiffSection) diffWords(text1, text2 string) []inlineOp {
+	words1, words2 := splitWords(text1), splitWords(text2)
+
+	// Encode each distinct word as a rune to diff sequences of words.
+	codes := make(map[string]rune)
+	encode := func(words []string) []rune {
+		runes := make([]rune, len(words))
+		for i, w := range words {
+			r, ok := codes[w]
+			if !ok {
+				// Use private use areas so encoded runes are always valid.
+				r = rune(0xE000 + len(codes))
+				if r > 0xF8FF {
+					r = rune(0xF0000 + len(codes) - 0x1900)
+				}
+				codes[w] = r
+			}
+			runes[i] = r
+		}
+		return runes
+	}
+	diffs := s.dmp.DiffMainRunes(encode(words1), encode(words2), false)
+
+	ops := make([]inlineOp, 0, len(diffs))
+	for _, d := range diffs {
+		n := utf8.RuneCountInString(d.Text)
+		words := &words1
+		if d.Type == diffmatchpatch.DiffInsert {
+			words = &words2
+		}
+		size := 0
+		for _, w := range (*words)[:n] {
+			size += len(w)
+		}
+		if d.Type == diffmatchpatch.DiffEqual {
+			words2 = words2[n:]
+		}
+		*words = (*words)[n:]
+		ops = append(ops, inlineOp{typ: d.Type, size: size})
+	}
+	return ops
+}
 
-	// Reproduce signs which are cutted for inline diff before.
-	switch lineType {
-	case git.DiffLineAdd:
-		buf.WriteByte('+')
-	case git.DiffLineDelete:
-		buf.WriteByte('-')
+// splitWords splits the text into words for diffing by words.
+func splitWords(text string) []string {
+	isWordChar := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
 	}
 
-	const (
-		addedCodePrefix   = `<span class="added-code">`
-		removedCodePrefix = `<span class="removed-code">`
-		codeTagSuffix     = `</span>`
-	)
+	var words []string
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		if isWordChar(r) {
+			for size < len(text) {
+				next, n := utf8.DecodeRuneInString(text[size:])
+				if !isWordChar(next) {
+					break
+				}
+				size += n
+			}
+		}
+		words = append(words, text[:size])
+		text = text[size:]
+	}
+	return words
+}
 
-	for i := range diffs {
+// opsToRanges returns ranges of inserted bytes for added lines, or of deleted
+// bytes for deleted lines, in contents with the leading sign.
+func opsToRanges(ops []inlineOp, lineType git.DiffLineType) []InlineRange {
+	ranges := make([]InlineRange, 0)
+	offset := 1
+	for _, op := range ops {
 		switch {
-		case diffs[i].Type == diffmatchpatch.DiffInsert && lineType == git.DiffLineAdd:
-			buf.WriteString(addedCodePrefix)
-			buf.WriteString(html.EscapeString(diffs[i].Text))
-			buf.WriteString(codeTagSuffix)
-		case diffs[i].Type == diffmatchpatch.DiffDelete && lineType == git.DiffLineDelete:
-			buf.WriteString(removedCodePrefix)
-			buf.WriteString(html.EscapeString(diffs[i].Text))
-			buf.WriteString(codeTagSuffix)
-		case diffs[i].Type == diffmatchpatch.DiffEqual:
-			buf.WriteString(html.EscapeString(diffs[i].Text))
+		case op.typ == diffmatchpatch.DiffEqual:
+			offset += op.size
+		case op.typ == diffmatchpatch.DiffInsert && lineType == git.DiffLineAdd,
+			op.typ == diffmatchpatch.DiffDelete && lineType == git.DiffLineDelete:
+			if op.size == 0 {
+				continue
+			}
+			// Merge with the previous range if adjacent.
+			if n := len(ranges); n > 0 && ranges[n-1].End == offset {
+				ranges[n-1].End += op.size
+			} else {
+				ranges = append(ranges, InlineRange{Start: offset, End: offset + op.size})
+			}
+			offset += op.size
 		}
 	}
+	return ranges
+}
 
-	return template.HTML(buf.Bytes())
+// BinaryBlob is a version of a changed binary file.
+type BinaryBlob struct {
+	SHA  string
+	Size int64
+	// URL of the raw file, and its dimensions in pixels if they can be decoded,
+	// only for images.
+	URL    string `json:",omitempty"`
+	Width  int    `json:",omitempty"`
+	Height int    `json:",omitempty"`
+}
+
+// BinaryDiff is the metadata of a changed binary file.
+type BinaryDiff struct {
+	IsImage bool
+	Old     *BinaryBlob `json:",omitempty"` // Nil if the file is added
+	New     *BinaryBlob `json:",omitempty"` // Nil if the file is deleted
 }
 
 // DiffFile is a wrapper to git.DiffFile with helper methods.
 type DiffFile struct {
 	*git.DiffFile
 	Sections []*DiffSection
+	// Only for binary files once loaded by LoadBinaryDiffs.
+	Binary *BinaryDiff `json:",omitempty"`
 }
 
 // HighlightClass returns the detected highlight class for the file.
@@ -124,6 +237,98 @@ type Diff struct {
 	Files []*DiffFile
 }
 
+// HighlightInline sets inline highlights of all paired lines, by characters or
+// by words if wordLevel.
+func (d *Diff) HighlightInline(wordLevel bool) {
+	for _, f := range d.Files {
+		for _, s := range f.Sections {
+			for _, line := range s.Lines {
+				line.Highlights = s.ComputedInlineRangesFor(line.DiffLine, wordLevel)
+			}
+		}
+	}
+}
+
+// imageExts are extensions of images that can be shown by browsers.
+var imageExts = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+	".bmp":  true,
+	".ico":  true,
+	".svg":  true,
+}
+
+// maxImageHeaderSize is the max size of the head of an image to be read for
+// its dimensions.
+const maxImageHeaderSize = 1 << 20
+
+// loadBinaryBlob returns the version of a binary file by its blob SHA.
+func loadBinaryBlob(repo *git.Repository, sha string, isImage bool) (*BinaryBlob, error) {
+	blob, err := repo.CatFileBlob(sha)
+	if err != nil {
+		return nil, fmt.Errorf("get blob %q: %v", sha, err)
+	}
+	b := &BinaryBlob{
+		SHA:  sha,
+		Size: blob.Size(),
+	}
+	if !isImage {
+		return b, nil
+	}
+
+	r := BlobReader(blob)
+	defer r.Close()
+	// Formats without decoders (e.g. WebP) are left without dimensions.
+	if config, _, err := image.DecodeConfig(io.LimitReader(r, maxImageHeaderSize)); err == nil {
+		b.Width, b.Height = config.Width, config.Height
+	}
+	return b, nil
+}
+
+// isZeroSHA returns true if the SHA stands for a missing blob.
+func isZeroSHA(sha string) bool {
+	return strings.Trim(sha, "0") == ""
+}
+
+// LoadBinaryDiffs loads metadata of changed binary files of the diff from the
+// repository, where rawURL returns the URL of the raw file with the name at
+// the old or new revision of the diff.
+func (d *Diff) LoadBinaryDiffs(repo *git.Repository, rawURL func(isOld bool, name string) string) error {
+	for _, f := range d.Files {
+		if !f.IsBinary() || f.IsSubmodule() {
+			continue
+		}
+
+		oldName := f.Name
+		if f.OldName() != "" {
+			oldName = f.OldName()
+		}
+		binary := &BinaryDiff{IsImage: imageExts[strings.ToLower(path.Ext(f.Name))]}
+		var err error
+		if !f.IsCreated() && !isZeroSHA(f.OldIndex) {
+			if binary.Old, err = loadBinaryBlob(repo, f.OldIndex, binary.IsImage); err != nil {
+				return err
+			}
+			if binary.IsImage {
+				binary.Old.URL = rawURL(true, oldName)
+			}
+		}
+		if !f.IsDeleted() && !isZeroSHA(f.Index) {
+			if binary.New, err = loadBinaryBlob(repo, f.Index, binary.IsImage); err != nil {
+				return err
+			}
+			if binary.IsImage {
+				binary.New.URL = rawURL(false, f.Name)
+			}
+		}
+		f.Binary = binary
+	}
+	return nil
+}
+
 // NewDiff returns a new wrapper of given git.Diff.
 func NewDiff(oldDiff *git.Diff) *Diff {
 	newDiff := &Diff{
@@ -144,6 +349,10 @@ func NewDiff(oldDiff *git.Diff) *Diff {
 		for j := range oldDiff.Files[i].Sections {
 			newDiff.Files[i].Sections[j] = &DiffSection{
 				DiffSection: oldDiff.Files[i].Sections[j],
+				Lines:       make([]*DiffLine, len(oldDiff.Files[i].Sections[j].Lines)),
+			}
+			for k, line := range oldDiff.Files[i].Sections[j].Lines {
+				newDiff.Files[i].Sections[j].Lines[k] = &DiffLine{DiffLine: line}
 			}
 
 			for k := range newDiff.Files[i].Sections[j].Lines {
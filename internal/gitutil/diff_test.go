@@ -0,0 +1,49 @@
+package gitutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff_HighlightInline(t *testing.T) {
+	const raw = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,3 @@
+ package main
+-var fooBar = 1
++var fooBaz = 2
+ func main() {}
+`
+	highlights := func(wordLevel bool) (deleted, added []InlineRange) {
+		diff, err := ParseDiff(strings.NewReader(raw), 0, 0, 0)
+		require.NoError(t, err)
+		diff.HighlightInline(wordLevel)
+		require.Len(t, diff.Files, 1)
+		require.Len(t, diff.Files[0].Sections, 1)
+		lines := diff.Files[0].Sections[0].Lines
+		require.Len(t, lines, 5)
+		assert.Empty(t, lines[0].Highlights) // Section header
+		assert.Empty(t, lines[1].Highlights) // Unchanged line
+		return lines[2].Highlights, lines[3].Highlights
+	}
+
+	// "-var fooBar = 1" and "+var fooBaz = 2"
+	// Nearby changes of characters are merged for efficiency.
+	deleted, added := highlights(false)
+	assert.Equal(t, []InlineRange{{Start: 10, End: 15}}, deleted)
+	assert.Equal(t, []InlineRange{{Start: 10, End: 15}}, added)
+
+	deleted, added = highlights(true)
+	assert.Equal(t, []InlineRange{{Start: 5, End: 11}, {Start: 14, End: 15}}, deleted)
+	assert.Equal(t, []InlineRange{{Start: 5, End: 11}, {Start: 14, End: 15}}, added)
+}
+
+func TestSplitWords(t *testing.T) {
+	assert.Equal(t, []string{"foo_bar", "(", "x", ",", " ", "数据", ")"}, splitWords("foo_bar(x, 数据)"))
+	assert.Empty(t, splitWords(""))
+}
@@ -0,0 +1,96 @@
+package markup
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday/v2"
+)
+
+// ReadmeNames are names of README files in order of preference.
+var ReadmeNames = []string{"README.md", "README.markdown", "README.txt", "README"}
+
+// IsMarkdownFile returns true if the file is a markdown file by its name.
+func IsMarkdownFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return false
+}
+
+// sanitizer removes unsafe elements and attributes from rendered HTML.
+var sanitizer = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Languages of code blocks for highlighting by clients.
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	return p
+}()
+
+// resolveLink returns the link resolved against the prefix if it is relative,
+// where the link is relative to the directory, or to the prefix itself if it
+// starts with "/".
+func resolveLink(link, prefix, dir string) string {
+	if link == "" || strings.HasPrefix(link, "#") {
+		return link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.IsAbs() || u.Host != "" || u.Path == "" {
+		return link
+	}
+
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join("/", dir, p)
+	}
+	// Links never go above the prefix.
+	u.Path = strings.TrimPrefix(path.Clean(p), "/")
+	return strings.TrimSuffix(prefix, "/") + "/" + u.String()
+}
+
+// linkRenderer renders links and images with relative destinations resolved.
+type linkRenderer struct {
+	*blackfriday.HTMLRenderer
+	prefix string
+	dir    string
+}
+
+func (r *linkRenderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	if entering && (node.Type == blackfriday.Link || node.Type == blackfriday.Image) {
+		node.LinkData.Destination = []byte(resolveLink(string(node.LinkData.Destination), r.prefix, r.dir))
+	}
+	return r.HTMLRenderer.RenderNode(w, node, entering)
+}
+
+// Markdown renders the markdown content to sanitized HTML. Relative links and
+// images are resolved against the prefix, where the document is in given
+// directory relative to the prefix.
+func Markdown(content []byte, prefix, dir string) []byte {
+	renderer := &linkRenderer{
+		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+			Flags: blackfriday.CommonHTMLFlags,
+		}),
+		prefix: prefix,
+		dir:    dir,
+	}
+	output := blackfriday.Run(content,
+		blackfriday.WithRenderer(renderer),
+		blackfriday.WithExtensions(blackfriday.CommonExtensions|blackfriday.AutoHeadingIDs),
+	)
+	return sanitizer.SanitizeBytes(output)
+}
+
+// PlainText renders the text content to HTML as is.
+func PlainText(content []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<pre>")
+	buf.WriteString(html.EscapeString(string(content)))
+	buf.WriteString("</pre>")
+	return buf.Bytes()
+}
@@ -0,0 +1,55 @@
+package markup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveLink(t *testing.T) {
+	const prefix = "http://localhost:4000/alice/repo/raw/master"
+	tests := []struct {
+		link string
+		dir  string
+		want string
+	}{
+		{link: "docs/guide.md", want: prefix + "/docs/guide.md"},
+		{link: "../logo.png", dir: "docs", want: prefix + "/logo.png"},
+		{link: "../../../etc/passwd", dir: "docs", want: prefix + "/etc/passwd"},
+		{link: "/LICENSE", dir: "docs", want: prefix + "/LICENSE"},
+		{link: "a b.md#usage", dir: "docs", want: prefix + "/docs/a%20b.md#usage"},
+		{link: "#usage", want: "#usage"},
+		{link: "https://example.com/x.png", want: "https://example.com/x.png"},
+		{link: "//example.com/x.png", want: "//example.com/x.png"},
+		{link: "mailto:a@example.com", want: "mailto:a@example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.link, func(t *testing.T) {
+			assert.Equal(t, test.want, resolveLink(test.link, prefix, test.dir))
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	const prefix = "http://localhost:4000/alice/repo/raw/master/"
+	got := string(Markdown([]byte(`# Title
+
+![logo](images/logo.png) [guide](../guide.md)
+
+<script>alert(1)</script>
+<a href="javascript:alert(1)" onclick="alert(1)">x</a>
+
+`+"```go\nfunc main() {}\n```\n"), prefix, "docs"))
+
+	assert.Contains(t, got, `<h1 id="title">Title</h1>`)
+	assert.Contains(t, got, `<img src="http://localhost:4000/alice/repo/raw/master/docs/images/logo.png" alt="logo"/>`)
+	assert.Contains(t, got, `<a href="http://localhost:4000/alice/repo/raw/master/guide.md" rel="nofollow">guide</a>`)
+	assert.Contains(t, got, `<code class="language-go">`)
+	assert.NotContains(t, got, "<script>")
+	assert.NotContains(t, got, "javascript:")
+	assert.NotContains(t, got, "onclick")
+}
+
+func TestPlainText(t *testing.T) {
+	assert.Equal(t, "<pre>a &lt;b&gt;\n</pre>", string(PlainText([]byte("a <b>\n"))))
+}
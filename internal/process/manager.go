@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"sync"
 	"time"
@@ -76,19 +78,47 @@ func Remove(pid int64) bool {
 
 // Exec starts executing a shell command in given path, it tracks corresponding process and timeout.
 func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (string, string, error) {
+	bufOut := new(bytes.Buffer)
+	stderr, err := ExecDirWriter(timeout, dir, desc, bufOut, cmdName, args...)
+	if err == ErrExecTimeout {
+		return "", stderr, err
+	}
+	return bufOut.String(), stderr, err
+}
+
+// ExecDirEnv is like ExecDir but runs the command with given environment
+// variables in addition to the ones of the current process.
+func ExecDirEnv(timeout time.Duration, dir, desc string, env []string, cmdName string, args ...string) (string, string, error) {
+	bufOut := new(bytes.Buffer)
+	stderr, err := execDirEnvWriter(timeout, dir, desc, env, bufOut, cmdName, args...)
+	if err == ErrExecTimeout {
+		return "", stderr, err
+	}
+	return bufOut.String(), stderr, err
+}
+
+// ExecDirWriter is like ExecDir but streams standard output to the writer
+// instead of buffering it, and returns standard error.
+func ExecDirWriter(timeout time.Duration, dir, desc string, w io.Writer, cmdName string, args ...string) (string, error) {
+	return execDirEnvWriter(timeout, dir, desc, nil, w, cmdName, args...)
+}
+
+func execDirEnvWriter(timeout time.Duration, dir, desc string, env []string, w io.Writer, cmdName string, args ...string) (string, error) {
 	if timeout == -1 {
 		timeout = DEFAULT_TIMEOUT
 	}
 
-	bufOut := new(bytes.Buffer)
 	bufErr := new(bytes.Buffer)
 
 	cmd := exec.Command(cmdName, args...)
 	cmd.Dir = dir
-	cmd.Stdout = bufOut
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = w
 	cmd.Stderr = bufErr
 	if err := cmd.Start(); err != nil {
-		return "", err.Error(), err
+		return err.Error(), err
 	}
 
 	pid := Add(desc, cmd)
@@ -104,12 +134,12 @@ func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (
 			log.Error("Failed to kill timeout process [pid: %d, desc: %s]: %v", pid, desc, errKill)
 		}
 		<-done
-		return "", ErrExecTimeout.Error(), ErrExecTimeout
+		return ErrExecTimeout.Error(), ErrExecTimeout
 	case err = <-done:
 	}
 
 	Remove(pid)
-	return bufOut.String(), bufErr.String(), err
+	return bufErr.String(), err
 }
 
 // Exec starts executing a shell command, it tracks corresponding process and timeout.
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	processed "git-server/internal/process"
+	"git-server/internal/type"
+)
+
+// BlameHunk is a range of consecutive lines last changed by the same commit.
+type BlameHunk struct {
+	CommitID    string
+	Author      string
+	AuthorEmail string
+	When        time.Time
+	Summary     string
+	StartLine   int // 1-based
+	EndLine     int // Inclusive
+}
+
+// blameCacheSize is the max number of files whose blame is cached.
+const blameCacheSize = 512
+
+// blameCache caches blame of files by commit ID and path, which never changes.
+var blameCache = struct {
+	sync.Mutex
+	hunks map[string][]*BlameHunk
+}{hunks: make(map[string][]*BlameHunk)}
+
+// GetBlame returns blame hunks of the file at given commit of the repository.
+func GetBlame(repoPath, commitID, treePath string) ([]*BlameHunk, error) {
+	key := repoPath + ":" + commitID + ":" + treePath
+	blameCache.Lock()
+	hunks, ok := blameCache.hunks[key]
+	blameCache.Unlock()
+	if ok {
+		return hunks, nil
+	}
+
+	stdout, stderr, err := processed.ExecDir(gitTimeout(conf.Git.Timeout.Blame), repoPath,
+		fmt.Sprintf("GetBlame (git blame): %s:%s", commitID, treePath),
+		"git", "blame", "--porcelain", commitID, "--", treePath)
+	if err != nil {
+		return nil, fmt.Errorf("git blame: %v - %s", err, stderr)
+	}
+	hunks, err = parseBlamePorcelain(stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	blameCache.Lock()
+	if len(blameCache.hunks) >= blameCacheSize {
+		// Evict an arbitrary entry, which is good enough for immutable data.
+		for k := range blameCache.hunks {
+			delete(blameCache.hunks, k)
+			break
+		}
+	}
+	blameCache.hunks[key] = hunks
+	blameCache.Unlock()
+	return hunks, nil
+}
+
+// parseBlamePorcelain parses output of "git blame --porcelain" into hunks, with
+// adjacent lines of the same commit merged.
+func parseBlamePorcelain(stdout string) ([]*BlameHunk, error) {
+	var (
+		hunks   []*BlameHunk
+		commits = make(map[string]*BlameHunk) // Commit information by commit ID
+		current *BlameHunk                    // Commit information of the current line
+		line    int
+	)
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if strings.HasPrefix(text, "\t") {
+			// The content of the line concludes its entry.
+			if current == nil {
+				return nil, errors.New("unexpected line content")
+			}
+			if n := len(hunks); n > 0 && hunks[n-1].CommitID == current.CommitID && hunks[n-1].EndLine == line-1 {
+				hunks[n-1].EndLine = line
+			} else {
+				hunk := *current
+				hunk.StartLine, hunk.EndLine = line, line
+				hunks = append(hunks, &hunk)
+			}
+			continue
+		}
+
+		key, value, _ := strings.Cut(text, " ")
+		if fields := strings.Fields(text); len(key) == 40 && len(fields) >= 3 {
+			// A header of "<commit> <original line> <final line> [<number of lines>]".
+			var err error
+			if line, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, fmt.Errorf("bad header %q", text)
+			}
+			if current = commits[key]; current == nil {
+				current = &BlameHunk{CommitID: key}
+				commits[key] = current
+			}
+			continue
+		} else if current == nil {
+			return nil, fmt.Errorf("unexpected line %q", text)
+		}
+
+		switch key {
+		case "author":
+			current.Author = value
+		case "author-mail":
+			current.AuthorEmail = strings.Trim(value, "<>")
+		case "author-time":
+			sec, _ := strconv.ParseInt(value, 10, 64)
+			current.When = time.Unix(sec, 0)
+		case "summary":
+			current.Summary = value
+		}
+	}
+	return hunks, scanner.Err()
+}
+
+// Blame returns line attribution of the file at the reference.
+func Blame(c *context.Context) {
+	if c.Repo.TreePath == "" {
+		c.JSON(500, _type.FaildResult(errors.New("path is required")))
+		return
+	}
+	if _, err := c.Repo.Commit.Blob(c.Repo.TreePath); err != nil {
+		c.JSON(404, _type.FaildResult(err))
+		return
+	}
+
+	hunks, err := GetBlame(c.Repo.GitRepo.Path(), c.Repo.CommitID, c.Repo.TreePath)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(hunks))
+}
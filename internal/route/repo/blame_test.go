@@ -0,0 +1,40 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBlame(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	first := strings.TrimSpace(runGit(t, repoPath("alice/repo"), "rev-parse", "HEAD"))
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "README.md"), []byte("# test\nsecond\nthird\n"), 0644))
+	runGit(t, workDir, "commit", "-am", "add lines")
+	runGit(t, workDir, "push", "origin", "master")
+	second := strings.TrimSpace(runGit(t, repoPath("alice/repo"), "rev-parse", "HEAD"))
+
+	hunks, err := GetBlame(repoPath("alice/repo"), second, "README.md")
+	require.NoError(t, err)
+	require.Len(t, hunks, 2)
+	assert.Equal(t, first, hunks[0].CommitID)
+	assert.Equal(t, "initial commit", hunks[0].Summary)
+	assert.Equal(t, "tester", hunks[0].Author)
+	assert.Equal(t, "tester@example.com", hunks[0].AuthorEmail)
+	assert.Equal(t, [2]int{1, 1}, [2]int{hunks[0].StartLine, hunks[0].EndLine})
+	assert.Equal(t, second, hunks[1].CommitID)
+	assert.Equal(t, "add lines", hunks[1].Summary)
+	assert.Equal(t, [2]int{2, 3}, [2]int{hunks[1].StartLine, hunks[1].EndLine})
+	assert.False(t, hunks[1].When.IsZero())
+
+	_, err = GetBlame(repoPath("alice/repo"), second, "missing.md")
+	assert.Error(t, err)
+}
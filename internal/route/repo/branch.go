@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"git-server/internal/conf"
 	"git-server/internal/context"
+	processed "git-server/internal/process"
 	"git-server/internal/type"
 	"github.com/gogs/git-module"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -83,28 +87,149 @@ func GetCommit(branch, repoPath string) (*git.Commit, error) {
 	}
 	return gitRepo.BranchCommit(branch)
 }
+
+// BranchDivergence is how a branch relates to its base branch.
+type BranchDivergence struct {
+	Base   string
+	Ahead  int // Number of commits on the branch but not on the base
+	Behind int // Number of commits on the base but not on the branch
+	// Whether the branch is merged into the base by a merge commit. Branches
+	// that have never diverged from the base are not merged.
+	IsMerged bool
+}
+
+// GetBranchDivergence compares the branch with the base branch.
+func GetBranchDivergence(repoPath, base, branch string) (*BranchDivergence, error) {
+	stdout, stderr, err := processed.ExecDir(gitTimeout(conf.Git.Timeout.Diff), repoPath,
+		fmt.Sprintf("GetBranchDivergence (git rev-list): %s...%s", base, branch),
+		"git", "rev-list", "--left-right", "--count", git.RefsHeads+base+"..."+git.RefsHeads+branch, "--")
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list: %v - %s", err, stderr)
+	}
+
+	fields := strings.Fields(stdout)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("unexpected output of git rev-list: %q", stdout)
+	}
+	d := &BranchDivergence{Base: base}
+	d.Behind, _ = strconv.Atoi(fields[0])
+	d.Ahead, _ = strconv.Atoi(fields[1])
+	if d.Ahead == 0 && d.Behind > 0 {
+		if d.IsMerged, err = isMergedByCommit(repoPath, base, branch); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// isMergedByCommit returns true if the branch, which has no commits that are
+// not on the base branch, is merged by a merge commit. Otherwise its tip is on
+// the first-parent history of the base branch, e.g. a new branch created from
+// the base branch.
+func isMergedByCommit(repoPath, base, branch string) (bool, error) {
+	stdout, stderr, err := processed.ExecDir(-1, repoPath,
+		fmt.Sprintf("isMergedByCommit (git rev-parse): %s", branch),
+		"git", "rev-parse", "--verify", git.RefsHeads+branch)
+	if err != nil {
+		return false, fmt.Errorf("git rev-parse: %v - %s", err, stderr)
+	}
+	tip := strings.TrimSpace(stdout)
+
+	// The last commit is the oldest one on the first-parent history of the base
+	// branch since the tip, its first parent is the tip if the tip is on the history.
+	stdout, stderr, err = processed.ExecDir(gitTimeout(conf.Git.Timeout.Diff), repoPath,
+		fmt.Sprintf("isMergedByCommit (git rev-list): %s..%s", branch, base),
+		"git", "rev-list", "--first-parent", "--parents", git.RefsHeads+base, "^"+tip, "--")
+	if err != nil {
+		return false, fmt.Errorf("git rev-list: %v - %s", err, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	return len(fields) >= 2 && fields[1] != tip, nil
+}
+
+// AllBranches lists the default branch and other branches compared with the
+// base branch in "base", which is the default branch if empty. Other branches
+// are filtered by the keyword in "q", sorted by "sort" which is either "name"
+// (default) or "updated" (recently updated first), and paginated by "page" and
+// "pageSize" if given.
 func AllBranches(c *context.Context) {
 	branches, err := loadBranches(c)
 	if err != nil {
 		c.JSON(500, _type.FaildResult(err))
 		return
 	}
+
+	base := c.Query("base")
+	if base == "" {
+		base = c.Repo.BranchName
+	} else if !c.Repo.GitRepo.HasBranch(base) {
+		c.JSON(404, _type.FaildResult(fmt.Errorf("branch %q does not exist", base)))
+		return
+	}
+
+	keyword := strings.ToLower(c.Query("q"))
 	var DefaultBranch *Branch
 	OtherBranches := make([]*Branch, 0)
 	for i := range branches {
 		switch {
 		case branches[i].Name == c.Repo.BranchName:
 			DefaultBranch = branches[i]
-		default:
+		case strings.Contains(strings.ToLower(branches[i].Name), keyword):
 			OtherBranches = append(OtherBranches, branches[i])
 		}
 	}
+
+	switch sortBy := c.Query("sort"); sortBy {
+	case "", "name":
+		sort.SliceStable(OtherBranches, func(i, j int) bool {
+			return OtherBranches[i].Name < OtherBranches[j].Name
+		})
+	case "updated":
+		sort.SliceStable(OtherBranches, func(i, j int) bool {
+			return OtherBranches[i].Commit.Committer.When.After(OtherBranches[j].Commit.Committer.When)
+		})
+	default:
+		c.JSON(400, _type.FaildResult(fmt.Errorf("invalid sort %q", sortBy)))
+		return
+	}
+
+	total := len(OtherBranches)
+	if pageSize := c.QueryInt("pageSize"); pageSize > 0 {
+		page := c.QueryInt("page")
+		if page < 1 {
+			page = 1
+		}
+		start, end := (page-1)*pageSize, page*pageSize
+		if start > total {
+			start = total
+		}
+		if end > total {
+			end = total
+		}
+		OtherBranches = OtherBranches[start:end]
+	}
+
+	// Only branches to be returned are compared, which is relatively expensive.
+	repoPath := c.Repo.GitRepo.Path()
+	for _, b := range append([]*Branch{DefaultBranch}, OtherBranches...) {
+		if b == nil {
+			continue
+		}
+		if b.Divergence, err = GetBranchDivergence(repoPath, base, b.Name); err != nil {
+			c.JSON(500, _type.FaildResult(err))
+			return
+		}
+	}
+
 	type result struct {
 		DefaultBranch *Branch
 		OtherBranches []*Branch
+		Total         int // Number of other branches matching the keyword
 	}
 	c.JSON(200, _type.SuccessResult(result{
 		DefaultBranch: DefaultBranch,
 		OtherBranches: OtherBranches,
+		Total:         total,
 	}))
 }
@@ -0,0 +1,290 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+	gouuid "github.com/satori/go.uuid"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	processed "git-server/internal/process"
+	"git-server/internal/type"
+)
+
+// refsDeleted is the prefix of hidden references that keep tips of deleted
+// branches from being garbage collected until they are purged.
+const refsDeleted = "refs/deleted/"
+
+// DeletedBranch is a deleted branch that can be restored.
+type DeletedBranch struct {
+	ID       string
+	Name     string
+	CommitID string // The tip of the branch when deleted
+	Deleted  time.Time
+	Expires  time.Time // Zero means never expires
+}
+
+// ErrBranchNotDeletable is returned when the branch must not be deleted.
+type ErrBranchNotDeletable struct {
+	Name   string
+	Reason string
+}
+
+func IsErrBranchNotDeletable(err error) bool {
+	_, ok := err.(ErrBranchNotDeletable)
+	return ok
+}
+
+func (err ErrBranchNotDeletable) Error() string {
+	return fmt.Sprintf("branch %q cannot be deleted: %s", err.Name, err.Reason)
+}
+
+// DeleteBranch deletes the branch of the repository, the tip of which is kept
+// to be restored within the retention period.
+func DeleteBranch(repoLink, name string) (*DeletedBranch, error) {
+	repoWorkingPool.CheckIn(repoLink)
+	defer repoWorkingPool.CheckOut(repoLink)
+
+	repoPath := repoPath(repoLink)
+	if name == "" || !git.RepoHasBranch(repoPath, name) {
+		return nil, errors.Errorf("branch %q does not exist", name)
+	}
+	head, err := git.SymbolicRef(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("get default branch: %v", err)
+	} else if head == git.RefsHeads+name {
+		return nil, ErrBranchNotDeletable{Name: name, Reason: "it is the default branch"}
+	}
+	protected, err := getProtectedBranches(repoLink)
+	if err != nil {
+		return nil, fmt.Errorf("get protected branches: %v", err)
+	}
+	for _, b := range protected {
+		if b == name {
+			return nil, ErrBranchNotDeletable{Name: name, Reason: "it is protected"}
+		}
+	}
+
+	stdout, stderr, err := processed.ExecDir(-1, repoPath,
+		fmt.Sprintf("DeleteBranch (git rev-parse): %s", repoLink),
+		"git", "rev-parse", "--verify", git.RefsHeads+name)
+	if err != nil {
+		return nil, fmt.Errorf("git rev-parse: %v - %s", err, stderr)
+	}
+
+	now := time.Now()
+	deleted := &DeletedBranch{
+		ID:       gouuid.NewV4().String(),
+		Name:     name,
+		CommitID: strings.TrimSpace(stdout),
+		Deleted:  now,
+	}
+	if conf.Repository.DeletedBranchRetention > 0 {
+		deleted.Expires = now.AddDate(0, 0, conf.Repository.DeletedBranchRetention)
+	}
+
+	for _, args := range [][]string{
+		// Hidden references are not advertised to clients.
+		{"config", "--replace-all", "transfer.hideRefs", strings.TrimSuffix(refsDeleted, "/"), "^" + strings.TrimSuffix(refsDeleted, "/") + "$"},
+		{"update-ref", refsDeleted + deleted.ID, deleted.CommitID, ""},
+		{"update-ref", "-d", git.RefsHeads + name, deleted.CommitID},
+	} {
+		if _, stderr, err = processed.ExecDir(-1, repoPath,
+			fmt.Sprintf("DeleteBranch (git %s): %s", args[0], repoLink),
+			"git", args...); err != nil {
+			return nil, fmt.Errorf("git %s: %v - %s", args[0], err, stderr)
+		}
+	}
+
+	if err = UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		meta.DeletedBranches = append(meta.DeletedBranches, deleted)
+	}); err != nil {
+		return nil, fmt.Errorf("update repository meta: %v", err)
+	}
+	return deleted, nil
+}
+
+// RestoreBranch recreates the deleted branch by given ID at its tip when deleted.
+func RestoreBranch(repoLink, id string) (*DeletedBranch, error) {
+	repoWorkingPool.CheckIn(repoLink)
+	defer repoWorkingPool.CheckOut(repoLink)
+
+	meta, err := GetRepoMeta(repoLink)
+	if err != nil {
+		return nil, err
+	}
+	var deleted *DeletedBranch
+	for _, b := range meta.DeletedBranches {
+		if b.ID == id {
+			deleted = b
+			break
+		}
+	}
+	if deleted == nil {
+		return nil, errors.Errorf("deleted branch %q does not exist", id)
+	}
+
+	repoPath := repoPath(repoLink)
+	if git.RepoHasBranch(repoPath, deleted.Name) {
+		return nil, errors.Errorf("branch %q already exists", deleted.Name)
+	}
+	if _, stderr, err := processed.ExecDir(-1, repoPath,
+		fmt.Sprintf("RestoreBranch (git update-ref): %s", repoLink),
+		"git", "update-ref", git.RefsHeads+deleted.Name, deleted.CommitID, ""); err != nil {
+		return nil, fmt.Errorf("git update-ref: %v - %s", err, stderr)
+	}
+
+	if err = removeDeletedBranches(repoLink, func(b *DeletedBranch) bool {
+		return b.ID == id
+	}); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// removeDeletedBranches removes records and hidden references of deleted
+// branches that match the filter.
+func removeDeletedBranches(repoLink string, filter func(b *DeletedBranch) bool) error {
+	var removed []*DeletedBranch
+	if err := UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		kept := meta.DeletedBranches[:0]
+		for _, b := range meta.DeletedBranches {
+			if filter(b) {
+				removed = append(removed, b)
+			} else {
+				kept = append(kept, b)
+			}
+		}
+		meta.DeletedBranches = kept
+	}); err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	}
+
+	for _, b := range removed {
+		if _, stderr, err := processed.ExecDir(-1, repoPath(repoLink),
+			fmt.Sprintf("removeDeletedBranches (git update-ref): %s", repoLink),
+			"git", "update-ref", "-d", refsDeleted+b.ID); err != nil {
+			log.Error("Failed to remove reference of deleted branch %q [repo: %s]: %v - %s", b.Name, repoLink, err, stderr)
+		}
+	}
+	return nil
+}
+
+// PurgeDeletedBranches removes expired deleted branches of the repository.
+func PurgeDeletedBranches(repoLink string) error {
+	now := time.Now()
+	return removeDeletedBranches(repoLink, func(b *DeletedBranch) bool {
+		return !b.Expires.IsZero() && b.Expires.Before(now)
+	})
+}
+
+// DeleteMergedBranches deletes branches that are merged into the default branch,
+// except protected ones, and returns deleted branches. Branches are only listed
+// with their tips but not deleted if dryRun is true.
+func DeleteMergedBranches(repoLink string, dryRun bool) ([]*DeletedBranch, error) {
+	repoPath := repoPath(repoLink)
+	head, err := git.SymbolicRef(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("get default branch: %v", err)
+	}
+	base := strings.TrimPrefix(head, git.RefsHeads)
+	gitRepo, err := git.Open(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("open repository: %v", err)
+	}
+	branches, err := gitRepo.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("list branches: %v", err)
+	}
+	protected, err := getProtectedBranches(repoLink)
+	if err != nil {
+		return nil, fmt.Errorf("get protected branches: %v", err)
+	}
+	isProtected := make(map[string]bool, len(protected))
+	for _, b := range protected {
+		isProtected[b] = true
+	}
+
+	deleted := make([]*DeletedBranch, 0)
+	for _, name := range branches {
+		if name == base || isProtected[name] {
+			continue
+		}
+		d, err := GetBranchDivergence(repoPath, base, name)
+		if err != nil {
+			return deleted, err
+		} else if !d.IsMerged {
+			continue
+		}
+
+		if dryRun {
+			commitID, err := gitRepo.BranchCommitID(name)
+			if err != nil {
+				return deleted, fmt.Errorf("get commit ID of branch %q: %v", name, err)
+			}
+			deleted = append(deleted, &DeletedBranch{Name: name, CommitID: commitID})
+			continue
+		}
+		b, err := DeleteBranch(repoLink, name)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, b)
+	}
+	return deleted, nil
+}
+
+func DeleteBranchPost(c *context.Context) {
+	deleted, err := DeleteBranch(c.Repo.RepoLink, c.Params("*"))
+	if err != nil {
+		if IsErrBranchNotDeletable(err) {
+			c.JSON(403, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(deleted))
+}
+
+// DeleteMergedBranchesPost deletes merged branches, or only lists them if
+// "dryRun" is true.
+func DeleteMergedBranchesPost(c *context.Context) {
+	deleted, err := DeleteMergedBranches(c.Repo.RepoLink, c.QueryBool("dryRun"))
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(deleted))
+}
+
+func ListDeletedBranches(c *context.Context) {
+	if err := PurgeDeletedBranches(c.Repo.RepoLink); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	branches := meta.DeletedBranches
+	if branches == nil {
+		branches = make([]*DeletedBranch, 0)
+	}
+	c.JSON(200, _type.SuccessResult(branches))
+}
+
+func RestoreBranchPost(c *context.Context) {
+	restored, err := RestoreBranch(c.Repo.RepoLink, c.Params(":id"))
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(restored))
+}
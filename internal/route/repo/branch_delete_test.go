@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git-server/internal/conf"
+	"git-server/internal/form"
+)
+
+func TestDeleteBranch(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	repoPath := repoPath("alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath, ".")
+	runGit(t, workDir, "branch", "fresh")
+	runGit(t, workDir, "branch", "protected")
+	runGit(t, workDir, "checkout", "-b", "merged")
+	runGit(t, workDir, "commit", "--allow-empty", "-m", "merged work")
+	runGit(t, workDir, "checkout", "master")
+	runGit(t, workDir, "merge", "--no-ff", "-m", "merge branch", "merged")
+	runGit(t, workDir, "checkout", "-b", "feature")
+	runGit(t, workDir, "commit", "--allow-empty", "-m", "work in progress")
+	runGit(t, workDir, "push", "origin", "master", "fresh", "merged", "protected", "feature")
+	mergedTip := strings.TrimSpace(runGit(t, repoPath, "rev-parse", "merged"))
+	require.NoError(t, updateProtectedBranch("alice/repo", form.ProtectedBranch{BranchName: "protected", Protected: true}))
+	tip := strings.TrimSpace(runGit(t, repoPath, "rev-parse", "feature"))
+
+	_, err := DeleteBranch("alice/repo", "master")
+	assert.True(t, IsErrBranchNotDeletable(err))
+	_, err = DeleteBranch("alice/repo", "protected")
+	assert.True(t, IsErrBranchNotDeletable(err))
+	_, err = DeleteBranch("alice/repo", "missing")
+	assert.Error(t, err)
+
+	conf.Repository.DeletedBranchRetention = 1
+	deleted, err := DeleteBranch("alice/repo", "feature")
+	require.NoError(t, err)
+	assert.Equal(t, "feature", deleted.Name)
+	assert.Equal(t, tip, deleted.CommitID)
+	assert.False(t, deleted.Expires.IsZero())
+	assert.False(t, git.RepoHasBranch(repoPath, "feature"))
+
+	// The tip is kept but not advertised to clients.
+	runGit(t, repoPath, "cat-file", "-e", tip)
+	assert.NotContains(t, runGit(t, workDir, "ls-remote", "origin"), refsDeleted)
+
+	restored, err := RestoreBranch("alice/repo", deleted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "feature", restored.Name)
+	assert.Equal(t, tip, strings.TrimSpace(runGit(t, repoPath, "rev-parse", "feature")))
+	_, err = RestoreBranch("alice/repo", deleted.ID)
+	assert.Error(t, err)
+	meta, err := GetRepoMeta("alice/repo")
+	require.NoError(t, err)
+	assert.Empty(t, meta.DeletedBranches)
+
+	// Branches to delete are listed by a dry run.
+	all, err := DeleteMergedBranches("alice/repo", true)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "merged", all[0].Name)
+	assert.Equal(t, mergedTip, all[0].CommitID)
+	assert.True(t, git.RepoHasBranch(repoPath, "merged"))
+
+	// Only merged branches that are not protected are deleted in bulk, new
+	// branches that have never diverged are not merged.
+	all, err = DeleteMergedBranches("alice/repo", false)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "merged", all[0].Name)
+	assert.False(t, git.RepoHasBranch(repoPath, "merged"))
+	assert.True(t, git.RepoHasBranch(repoPath, "fresh"))
+	assert.True(t, git.RepoHasBranch(repoPath, "feature"))
+	assert.True(t, git.RepoHasBranch(repoPath, "protected"))
+
+	// Expired deleted branches are purged.
+	require.NoError(t, UpdateRepoMeta("alice/repo", func(meta *RepoMeta) {
+		meta.DeletedBranches[0].Expires = time.Now().Add(-time.Minute)
+	}))
+	require.NoError(t, PurgeDeletedBranches("alice/repo"))
+	meta, err = GetRepoMeta("alice/repo")
+	require.NoError(t, err)
+	assert.Empty(t, meta.DeletedBranches)
+	assert.Empty(t, strings.TrimSpace(runGit(t, repoPath, "for-each-ref", refsDeleted)))
+}
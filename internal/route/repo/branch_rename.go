@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+	"github.com/unknwon/com"
+
+	"git-server/internal/context"
+	"git-server/internal/form"
+	processed "git-server/internal/process"
+	"git-server/internal/type"
+)
+
+// RenameBranch renames the branch of the repository, and moves the default
+// branch and the protection of the branch to the new name if needed.
+func RenameBranch(repoLink, oldName, newName string) error {
+	repoWorkingPool.CheckIn(repoLink)
+	defer repoWorkingPool.CheckOut(repoLink)
+
+	repoPath := repoPath(repoLink)
+	if oldName == "" || !git.RepoHasBranch(repoPath, oldName) {
+		return ErrInvalidArgument{fmt.Sprintf("branch %q does not exist", oldName)}
+	}
+	if _, _, err := processed.Exec(fmt.Sprintf("RenameBranch (git check-ref-format): %s", newName),
+		"git", "check-ref-format", "--branch", newName); err != nil || newName == "" {
+		return ErrInvalidArgument{fmt.Sprintf("invalid branch name %q", newName)}
+	}
+	if oldName == newName {
+		return nil
+	} else if git.RepoHasBranch(repoPath, newName) {
+		return ErrInvalidArgument{fmt.Sprintf("branch %q already exists", newName)}
+	}
+
+	head, err := git.SymbolicRef(repoPath)
+	if err != nil {
+		return fmt.Errorf("get default branch: %v", err)
+	}
+	protected, err := getProtectedBranches(repoLink)
+	if err != nil {
+		return fmt.Errorf("get protected branches: %v", err)
+	}
+
+	// The new name is protected before renaming, so the branch is never
+	// unprotected even if the rename fails halfway.
+	moveProtection := com.IsSliceContainsStr(protected, oldName) && !com.IsSliceContainsStr(protected, newName)
+	if moveProtection {
+		if err = updateProtectedBranch(repoLink, form.ProtectedBranch{BranchName: newName, Protected: true}); err != nil {
+			return fmt.Errorf("protect new name: %v", err)
+		}
+	}
+	if _, stderr, err := processed.ExecDir(-1, repoPath,
+		fmt.Sprintf("RenameBranch (git branch -m): %s", repoLink),
+		"git", "branch", "-m", oldName, newName); err != nil {
+		if moveProtection {
+			_ = updateProtectedBranch(repoLink, form.ProtectedBranch{BranchName: newName, Protected: false})
+		}
+		return fmt.Errorf("git branch -m: %v - %s", err, stderr)
+	}
+	if head == git.RefsHeads+oldName {
+		if err = setDefaultBranch(repoPath, newName); err != nil {
+			return fmt.Errorf("update default branch: %v", err)
+		}
+	}
+
+	if moveProtection {
+		if err = updateProtectedBranch(repoLink, form.ProtectedBranch{BranchName: oldName, Protected: false}); err != nil {
+			return fmt.Errorf("unprotect old name: %v", err)
+		}
+	}
+	return nil
+}
+
+// retargetPullRequests points open pull requests whose base or head is the
+// renamed branch of the repository to its new name.
+func retargetPullRequests(repoLink, oldName, newName string, pulls []form.PullRequest) {
+	for i := range pulls {
+		pr := &pulls[i]
+		if pr.HasMerged || pr.IsClosed {
+			continue
+		}
+		resolvePullRequestRepos(pr)
+		if pr.BaseRepo == repoLink && pr.BaseBranch == oldName {
+			pr.BaseBranch = newName
+		}
+		if pr.HeadRepo == repoLink && pr.HeadBranch == oldName {
+			pr.HeadBranch = newName
+		}
+	}
+}
+
+// MustBeBranchRename responds with 404 unless the path of the branch ends with
+// "/rename", for globs of routes cannot be followed by other segments.
+func MustBeBranchRename(c *context.Context) {
+	if !strings.HasSuffix(c.Params("*"), "/rename") {
+		c.JSON(404, _type.FaildResult(errors.New("not found")))
+		return
+	}
+}
+
+// RenameBranchPost renames the branch in the path ending with "/rename", and
+// returns given pull requests retargeted to the new name.
+func RenameBranchPost(c *context.Context, f form.RenameBranch) {
+	oldName := strings.TrimSuffix(c.Params("*"), "/rename")
+	if err := RenameBranch(c.Repo.RepoLink, oldName, f.NewName); err != nil {
+		if IsErrInvalidArgument(err) {
+			c.JSON(400, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	if f.Pulls == nil {
+		f.Pulls = make([]form.PullRequest, 0)
+	}
+	retargetPullRequests(c.Repo.RepoLink, oldName, f.NewName, f.Pulls)
+	c.JSON(200, _type.SuccessResult(f))
+}
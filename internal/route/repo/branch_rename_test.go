@@ -0,0 +1,59 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git-server/internal/form"
+)
+
+func TestRenameBranch(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	repoPath := repoPath("alice/repo")
+	runGit(t, repoPath, "branch", "master-old", "master")
+	require.NoError(t, updateProtectedBranch("alice/repo", form.ProtectedBranch{BranchName: "master", Protected: true}))
+	require.NoError(t, updateProtectedBranch("alice/repo", form.ProtectedBranch{BranchName: "master-old", Protected: true}))
+
+	assert.True(t, IsErrInvalidArgument(RenameBranch("alice/repo", "missing", "main")))
+	assert.True(t, IsErrInvalidArgument(RenameBranch("alice/repo", "master", "bad..name")))
+	assert.True(t, IsErrInvalidArgument(RenameBranch("alice/repo", "master", "master-old")))
+
+	// The protection is kept on the old name if the rename fails.
+	lockPath := filepath.Join(repoPath, "refs", "heads", "main.lock")
+	require.NoError(t, os.WriteFile(lockPath, nil, 0644))
+	err := RenameBranch("alice/repo", "master", "main")
+	require.Error(t, err)
+	assert.False(t, IsErrInvalidArgument(err))
+	protected, err := getProtectedBranches("alice/repo")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"master", "master-old"}, protected)
+	require.NoError(t, os.Remove(lockPath))
+
+	require.NoError(t, RenameBranch("alice/repo", "master", "main"))
+	assert.False(t, git.RepoHasBranch(repoPath, "master"))
+	assert.True(t, git.RepoHasBranch(repoPath, "main"))
+	head, err := git.SymbolicRef(repoPath)
+	require.NoError(t, err)
+	assert.Equal(t, git.RefsHeads+"main", head)
+	protected, err = getProtectedBranches("alice/repo")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"main", "master-old"}, protected)
+
+	pulls := []form.PullRequest{
+		{IssueId: 1, BaseRepo: "alice/repo", BaseBranch: "master", HeadRepo: "alice/repo", HeadBranch: "feature"},
+		{IssueId: 2, BaseRepo: "bob/repo", BaseBranch: "master", HeadRepo: "alice/repo", HeadBranch: "master"},
+		{IssueId: 3, BaseRepo: "alice/repo", BaseBranch: "master", HeadRepo: "alice/repo", HeadBranch: "feature", IsClosed: true},
+	}
+	retargetPullRequests("alice/repo", "master", "main", pulls)
+	assert.Equal(t, "main", pulls[0].BaseBranch)
+	assert.Equal(t, "feature", pulls[0].HeadBranch)
+	assert.Equal(t, "master", pulls[1].BaseBranch)
+	assert.Equal(t, "main", pulls[1].HeadBranch)
+	assert.Equal(t, "master", pulls[2].BaseBranch)
+}
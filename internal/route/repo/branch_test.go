@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBranchDivergence(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	runGit(t, workDir, "branch", "fresh")
+	runGit(t, workDir, "checkout", "-b", "feature")
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(workDir, name), []byte(name), 0644))
+		runGit(t, workDir, "add", "--all")
+		runGit(t, workDir, "commit", "-m", "add "+name)
+	}
+	runGit(t, workDir, "checkout", "master")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "c.txt"), []byte("c"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add c.txt")
+	runGit(t, workDir, "checkout", "-b", "merged", "fresh")
+	runGit(t, workDir, "commit", "--allow-empty", "-m", "merged work")
+	runGit(t, workDir, "checkout", "master")
+	runGit(t, workDir, "merge", "--no-ff", "-m", "merge branch", "merged")
+	runGit(t, workDir, "push", "origin", "master", "feature", "fresh", "merged")
+
+	d, err := GetBranchDivergence(repoPath("alice/repo"), "master", "feature")
+	require.NoError(t, err)
+	assert.Equal(t, &BranchDivergence{Base: "master", Ahead: 2, Behind: 3}, d)
+
+	d, err = GetBranchDivergence(repoPath("alice/repo"), "master", "merged")
+	require.NoError(t, err)
+	assert.Equal(t, &BranchDivergence{Base: "master", Behind: 2, IsMerged: true}, d)
+
+	// A new branch that has never diverged is not merged.
+	d, err = GetBranchDivergence(repoPath("alice/repo"), "master", "fresh")
+	require.NoError(t, err)
+	assert.Equal(t, &BranchDivergence{Base: "master", Behind: 3}, d)
+
+	d, err = GetBranchDivergence(repoPath("alice/repo"), "feature", "master")
+	require.NoError(t, err)
+	assert.Equal(t, &BranchDivergence{Base: "feature", Ahead: 3, Behind: 2}, d)
+
+	_, err = GetBranchDivergence(repoPath("alice/repo"), "master", "missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,218 @@
+package repo
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+)
+
+const taskCleanup = "cleanup"
+
+// tempPath returns the directory for temporary files, which is emptied on startup.
+func tempPath() string {
+	return filepath.Join(conf.Repository.LocalPath, "tmp")
+}
+
+// createTempDir creates a new unique directory for temporary files with given prefix.
+func createTempDir(prefix string) (string, error) {
+	if err := os.MkdirAll(tempPath(), os.ModePerm); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(tempPath(), prefix)
+}
+
+// touchLocalCopy marks the local copy as recently used so it is not evicted.
+func touchLocalCopy(localPath string) {
+	now := time.Now()
+	if err := os.Chtimes(localPath, now, now); err != nil {
+		log.Warn("Failed to touch local copy %q: %v", localPath, err)
+	}
+}
+
+type localCopy struct {
+	repoLink string
+	size     int64
+	lastUsed time.Time
+}
+
+// getLocalCopies returns local copies of all repositories sorted from the least
+// recently used.
+func getLocalCopies() ([]localCopy, error) {
+	root := filepath.Join(conf.Repository.LocalPath, "localRepo")
+	owners, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var copies []localCopy
+	for _, owner := range owners {
+		if !owner.IsDir() {
+			continue
+		}
+		repos, err := os.ReadDir(filepath.Join(root, owner.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range repos {
+			if !r.IsDir() {
+				continue
+			}
+			info, err := r.Info()
+			if err != nil {
+				return nil, err
+			}
+			lc := localCopy{
+				repoLink: owner.Name() + "/" + r.Name(),
+				lastUsed: info.ModTime(),
+			}
+			_ = filepath.WalkDir(filepath.Join(root, owner.Name(), r.Name()), func(_ string, d fs.DirEntry, err error) error {
+				if err == nil && d.Type().IsRegular() {
+					if info, err := d.Info(); err == nil {
+						lc.size += info.Size()
+					}
+				}
+				return nil
+			})
+			copies = append(copies, lc)
+		}
+	}
+	sort.Slice(copies, func(i, j int) bool {
+		return copies[i].lastUsed.Before(copies[j].lastUsed)
+	})
+	return copies, nil
+}
+
+// evictLocalCopy removes the local copy of the repository, unless it has been
+// used after the given time.
+func evictLocalCopy(repoLink string, usedBefore time.Time) bool {
+	repoWorkingPool.CheckIn(repoLink)
+	defer repoWorkingPool.CheckOut(repoLink)
+
+	localPath := LocalCopyPath(repoLink)
+	info, err := os.Stat(localPath)
+	if err != nil || info.ModTime().After(usedBefore) {
+		return false
+	}
+	if err = os.RemoveAll(localPath); err != nil {
+		log.Error("Failed to remove local copy %q: %v", localPath, err)
+		return false
+	}
+	log.Trace("Evicted local copy: %s", localPath)
+	return true
+}
+
+// CleanupLocalCopies removes local copies unused for longer than the configured
+// idle time, then removes least recently used ones until all local copies fit
+// in the disk budget.
+func CleanupLocalCopies() {
+	copies, err := getLocalCopies()
+	if err != nil {
+		log.Error("CleanupLocalCopies: get local copies: %v", err)
+		return
+	}
+
+	var total int64
+	for _, lc := range copies {
+		total += lc.size
+	}
+
+	maxIdle := time.Duration(conf.Repository.LocalCopyMaxIdle) * time.Hour
+	budget := conf.Repository.LocalCopyMaxSize * mebibyte
+	for _, lc := range copies {
+		idle := maxIdle > 0 && time.Since(lc.lastUsed) > maxIdle
+		if !idle && (budget <= 0 || total <= budget) {
+			// Copies are sorted from the least recently used, none of the rest
+			// is idle either.
+			break
+		}
+		if evictLocalCopy(lc.repoLink, lc.lastUsed) {
+			total -= lc.size
+		}
+	}
+}
+
+// CleanupUploads removes uploaded files which are never committed.
+func CleanupUploads() {
+	if conf.Repository.UploadMaxAge <= 0 {
+		return
+	}
+	maxAge := time.Duration(conf.Repository.UploadMaxAge) * time.Hour
+	root := filepath.Join(conf.Repository.LocalPath, "uploads")
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > maxAge {
+			if err = os.Remove(p); err != nil {
+				log.Error("Failed to remove upload %q: %v", p, err)
+			}
+		}
+		return nil
+	})
+}
+
+// cleanupTempFiles removes temporary files left by operations interrupted by a
+// shutdown, it must only be called on startup.
+func cleanupTempFiles() {
+	// "data" was used for temporary copies of merges in previous versions.
+	for _, p := range []string{tempPath(), filepath.Join(conf.Repository.LocalPath, "data")} {
+		if err := os.RemoveAll(p); err != nil {
+			log.Error("Failed to remove temporary files %q: %v", p, err)
+		}
+	}
+
+	repos, err := getAllRepos()
+	if err != nil {
+		log.Error("cleanupTempFiles: list repositories: %v", err)
+		return
+	}
+	for _, r := range repos {
+		dir := filepath.Join(conf.Repository.Root, r, "pulls")
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasSuffix(e.Name(), ".patch") {
+				continue
+			}
+			if err = os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				log.Error("Failed to remove stale patch %q: %v", e.Name(), err)
+			}
+		}
+	}
+}
+
+// Cleanup removes unused local copies and uploads.
+func Cleanup() {
+	if taskStatusTable.IsRunning(taskCleanup) {
+		return
+	}
+	taskStatusTable.Start(taskCleanup)
+	defer taskStatusTable.Stop(taskCleanup)
+
+	log.Trace("Doing: Cleanup")
+	CleanupLocalCopies()
+	CleanupUploads()
+}
+
+// InitCleanup removes temporary files left by the last run and starts background
+// cleanup of local copies and uploads.
+func InitCleanup() {
+	cleanupTempFiles()
+	go func() {
+		for {
+			Cleanup()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
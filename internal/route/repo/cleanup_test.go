@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git-server/internal/conf"
+)
+
+func TestCleanupLocalCopies(t *testing.T) {
+	setupTestRoot(t)
+	for _, repoLink := range []string{"alice/old", "alice/mid", "alice/new"} {
+		newTestRepo(t, repoLink)
+		require.NoError(t, UpdateLocalCopyBranch(repoLink, "master"))
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(LocalCopyPath("alice/old"), past, past))
+	// Make the local copy large enough to exceed the budget.
+	require.NoError(t, os.WriteFile(filepath.Join(LocalCopyPath("alice/mid"), "large"), make([]byte, mebibyte), 0644))
+	past = time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(LocalCopyPath("alice/mid"), past, past))
+
+	// Idle copies are evicted.
+	conf.Repository.LocalCopyMaxIdle = 24
+	CleanupLocalCopies()
+	assert.NoDirExists(t, LocalCopyPath("alice/old"))
+	assert.DirExists(t, LocalCopyPath("alice/mid"))
+	assert.DirExists(t, LocalCopyPath("alice/new"))
+
+	// The least recently used copy is evicted to fit in the budget.
+	copies, err := getLocalCopies()
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	assert.Equal(t, "alice/mid", copies[0].repoLink)
+	conf.Repository.LocalCopyMaxSize = (copies[1].size + mebibyte - 1) / mebibyte
+	CleanupLocalCopies()
+	assert.NoDirExists(t, LocalCopyPath("alice/mid"))
+	assert.DirExists(t, LocalCopyPath("alice/new"))
+}
+
+func TestCleanupTempFiles(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	require.NoError(t, SavePatch(1, []byte("patch"), "alice/repo"))
+	pulls := filepath.Join(repoPath("alice/repo"), "pulls")
+	require.NoError(t, os.WriteFile(filepath.Join(pulls, "2.patch.123.tmp"), []byte("partial"), 0644))
+	tmpPath, err := createTempDir("merge-")
+	require.NoError(t, err)
+
+	upload := UploadLocalPath("0123abcd")
+	require.NoError(t, os.MkdirAll(filepath.Dir(upload), os.ModePerm))
+	require.NoError(t, os.WriteFile(upload, []byte("data"), 0644))
+	past := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(upload, past, past))
+
+	cleanupTempFiles()
+	assert.FileExists(t, filepath.Join(pulls, "1.patch"))
+	assert.NoFileExists(t, filepath.Join(pulls, "2.patch.123.tmp"))
+	assert.NoDirExists(t, tmpPath)
+
+	conf.Repository.UploadMaxAge = 24
+	CleanupUploads()
+	assert.NoFileExists(t, upload)
+}
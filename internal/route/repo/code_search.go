@@ -0,0 +1,450 @@
+package repo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/auth"
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	processed "git-server/internal/process"
+	"git-server/internal/search"
+	gsync "git-server/internal/sync"
+	"git-server/internal/tool"
+	"git-server/internal/type"
+)
+
+// IndexQueue holds repository links to be indexed for code search.
+var IndexQueue = gsync.NewUniqueQueue(1000)
+
+// indexCache holds recently used indexes by repository link, up to the max
+// number of the configuration.
+var indexCache = struct {
+	sync.Mutex
+	elements map[string]*list.Element // Values are *cachedIndex
+	recent   *list.List               // Most recently used at front
+}{
+	elements: make(map[string]*list.Element),
+	recent:   list.New(),
+}
+
+type cachedIndex struct {
+	repoLink string
+	idx      *search.Index
+}
+
+// getCachedIndex returns the cached index of the repository, or nil if not cached.
+func getCachedIndex(repoLink string) *search.Index {
+	indexCache.Lock()
+	defer indexCache.Unlock()
+	e, ok := indexCache.elements[repoLink]
+	if !ok {
+		return nil
+	}
+	indexCache.recent.MoveToFront(e)
+	return e.Value.(*cachedIndex).idx
+}
+
+// setCachedIndex caches the index of the repository, least recently used ones
+// are dropped beyond the max number of cached indexes.
+func setCachedIndex(repoLink string, idx *search.Index) {
+	indexCache.Lock()
+	defer indexCache.Unlock()
+	if e, ok := indexCache.elements[repoLink]; ok {
+		indexCache.recent.Remove(e)
+		delete(indexCache.elements, repoLink)
+	}
+	if conf.Search.MaxCachedIndexes <= 0 {
+		return
+	}
+	indexCache.elements[repoLink] = indexCache.recent.PushFront(&cachedIndex{repoLink: repoLink, idx: idx})
+	for indexCache.recent.Len() > conf.Search.MaxCachedIndexes {
+		e := indexCache.recent.Back()
+		indexCache.recent.Remove(e)
+		delete(indexCache.elements, e.Value.(*cachedIndex).repoLink)
+	}
+}
+
+// dropCachedIndex removes the cached index of the repository.
+func dropCachedIndex(repoLink string) {
+	indexCache.Lock()
+	defer indexCache.Unlock()
+	if e, ok := indexCache.elements[repoLink]; ok {
+		indexCache.recent.Remove(e)
+		delete(indexCache.elements, repoLink)
+	}
+}
+
+// IndexPath returns the path of the code search index of the repository.
+func IndexPath(repoLink string) string {
+	return filepath.Join(conf.Server.AppDataPath, "indexes", repoLink+".idx")
+}
+
+// loadIndex returns the code search index of the repository, or nil if the
+// repository has not been indexed or needs to be indexed again.
+func loadIndex(repoLink string) (*search.Index, error) {
+	if idx := getCachedIndex(repoLink); idx != nil {
+		return idx, nil
+	}
+
+	idx, err := search.Load(IndexPath(repoLink))
+	if os.IsNotExist(err) || err == search.ErrOutdated {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	setCachedIndex(repoLink, idx)
+	return idx, nil
+}
+
+// RemoveIndex removes the code search index of the repository.
+func RemoveIndex(repoLink string) {
+	dropCachedIndex(repoLink)
+	if err := os.Remove(IndexPath(repoLink)); err != nil && !os.IsNotExist(err) {
+		log.Error("Failed to remove index of %q: %v", repoLink, err)
+	}
+}
+
+// IndexRepository indexes text files on the default branch of the repository,
+// it does nothing if the index is already up to date.
+func IndexRepository(repoLink string) error {
+	repoPath := repoPath(repoLink)
+	if !repoExists(repoPath) {
+		RemoveIndex(repoLink)
+		return nil
+	}
+
+	stdout, _, err := processed.ExecDir(-1, repoPath,
+		fmt.Sprintf("IndexRepository (git rev-parse): %s", repoLink),
+		"git", "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	if err != nil {
+		// The repository is empty.
+		RemoveIndex(repoLink)
+		return nil
+	}
+	commitID := strings.TrimSpace(stdout)
+	if idx, err := loadIndex(repoLink); err == nil && idx != nil && idx.CommitID == commitID {
+		return nil
+	}
+
+	stdout, stderr, err := processed.ExecDir(-1, repoPath,
+		fmt.Sprintf("IndexRepository (git ls-tree): %s", repoLink),
+		"git", "ls-tree", "-r", "-z", commitID)
+	if err != nil {
+		return fmt.Errorf("git ls-tree: %v - %s", err, stderr)
+	}
+	blobIDs := make(map[string]string) // By path
+	for _, entry := range strings.Split(stdout, "\x00") {
+		// Format: "<mode> <type> <object>\t<path>"
+		info, treePath, ok := strings.Cut(entry, "\t")
+		if fields := strings.Fields(info); ok && len(fields) == 3 && fields[1] == "blob" {
+			blobIDs[treePath] = fields[2]
+		}
+	}
+
+	// Read while archiving so huge repositories are never held in memory as a whole.
+	r, w := io.Pipe()
+	type result struct {
+		files []search.File
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		files, err := readIndexFiles(r, blobIDs, conf.Search.MaxFileSize*1024)
+		// Drain what is left so git never blocks on writing.
+		_, _ = io.Copy(io.Discard, r)
+		done <- result{files, err}
+	}()
+	stderr, err = processed.ExecDirWriter(gitTimeout(conf.Git.Timeout.Clone), repoPath,
+		fmt.Sprintf("IndexRepository (git archive): %s", repoLink),
+		w, "git", "archive", "--format=tar", commitID)
+	_ = w.Close()
+	res := <-done
+	if err != nil {
+		return fmt.Errorf("git archive: %v - %s", err, stderr)
+	} else if res.err != nil {
+		return res.err
+	}
+
+	idx := search.NewIndex(commitID, res.files)
+	if err = idx.Save(IndexPath(repoLink)); err != nil {
+		return fmt.Errorf("save index: %v", err)
+	}
+	setCachedIndex(repoLink, idx)
+	return nil
+}
+
+// readIndexFiles returns text files to be indexed in the tar archive of the
+// tree, with their blob IDs by path and up to the max size unless it is zero.
+func readIndexFiles(r io.Reader, blobIDs map[string]string, maxSize int64) ([]search.File, error) {
+	var files []search.File
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || (maxSize > 0 && hdr.Size > maxSize) {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %v", hdr.Name, err)
+		}
+		blobID, ok := blobIDs[hdr.Name]
+		if !ok || bytes.IndexByte(data, 0) >= 0 {
+			// Skip binary files and those not in the tree as is.
+			continue
+		}
+		files = append(files, search.File{
+			Path:    hdr.Name,
+			BlobID:  blobID,
+			Content: string(data),
+		})
+	}
+}
+
+// blobBatchReader reads contents of indexed files from blobs of the repository
+// through a single "git cat-file --batch" process, which is started on the
+// first read.
+type blobBatchReader struct {
+	repoLink string
+	cmd      *exec.Cmd
+	pid      int64
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+}
+
+func (r *blobBatchReader) start() error {
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = repoPath(r.repoLink)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("start git cat-file: %v", err)
+	}
+	r.cmd, r.stdin, r.stdout = cmd, stdin, bufio.NewReader(stdout)
+	r.pid = processed.Add(fmt.Sprintf("SearchCode (git cat-file --batch): %s", r.repoLink), cmd)
+	return nil
+}
+
+// Read returns the content of the file.
+func (r *blobBatchReader) Read(f search.File) (string, error) {
+	if r.cmd == nil {
+		if err := r.start(); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := fmt.Fprintln(r.stdin, f.BlobID); err != nil {
+		return "", fmt.Errorf("write to git cat-file: %v", err)
+	}
+	header, err := r.stdout.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("read from git cat-file: %v", err)
+	}
+	// Format: "<object> <type> <size>", or "<object> missing" without content.
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return "", errors.Errorf("blob %q does not exist", f.BlobID)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", errors.Errorf("bad header %q from git cat-file", strings.TrimSpace(header))
+	}
+	// The content is followed by a newline.
+	data := make([]byte, size+1)
+	if _, err = io.ReadFull(r.stdout, data); err != nil {
+		return "", fmt.Errorf("read from git cat-file: %v", err)
+	}
+	if fields[1] != "blob" {
+		return "", errors.Errorf("object %q is a %s", f.BlobID, fields[1])
+	}
+	return string(data[:size]), nil
+}
+
+// Close stops the process if started.
+func (r *blobBatchReader) Close() error {
+	if r.cmd == nil {
+		return nil
+	}
+	defer processed.Remove(r.pid)
+	_ = r.stdin.Close()
+	return r.cmd.Wait()
+}
+
+// AddIndexing queues the repository to be indexed.
+func AddIndexing(repoLink string) {
+	IndexQueue.Add(repoLink)
+}
+
+// RunIndexing indexes repositories in the queue, it blocks until the queue is closed.
+func RunIndexing() {
+	for repoLink := range IndexQueue.Queue() {
+		log.Trace("RunIndexing [repo: %s]", repoLink)
+		if err := IndexRepository(repoLink); err != nil {
+			log.Error("RunIndexing [repo: %s]: %v", repoLink, err)
+		}
+		IndexQueue.Remove(repoLink)
+	}
+}
+
+// InitIndexing starts background indexing for code search, and queues all
+// repositories to catch up with changes made while the server was down.
+func InitIndexing() {
+	go RunIndexing()
+	go func() {
+		repos, err := getAllRepos()
+		if err != nil {
+			log.Error("InitIndexing: list repositories: %v", err)
+			return
+		}
+		for _, r := range repos {
+			AddIndexing(strings.TrimSuffix(r, ".git"))
+		}
+	}()
+}
+
+type codeMatch struct {
+	Repo string
+	search.Match
+}
+
+type codeSearchResult struct {
+	Matches     []codeMatch
+	IsTruncated bool // Whether there are more matches than returned
+}
+
+// minQueryLength is the min number of characters of a code search query.
+const minQueryLength = 3
+
+// SearchCode searches contents of default branches of repositories, which are
+// public ones or private ones accessible by the user of given credentials.
+// Repositories can be limited to an owner by "code", and the query is in "q"
+// which is a regular expression if "regexp" is true, and "path" filters paths
+// of files by a glob pattern.
+func SearchCode(c *context.Context) {
+	query := c.Query("q")
+	if utf8.RuneCountInString(query) < minQueryLength {
+		c.JSON(400, _type.FaildResult(errors.Errorf("query must be at least %d characters", minQueryLength)))
+		return
+	}
+
+	var user *auth.UserDetail
+	if authHead := c.Req.Header.Get("Authorization"); authHead != "" {
+		auths := strings.Fields(authHead)
+		if len(auths) != 2 || auths[0] != "Basic" {
+			c.JSON(401, _type.FaildResult(errors.New("invalid credentials")))
+			return
+		}
+		username, password, err := tool.BasicAuthDecode(auths[1])
+		if err == nil {
+			user, err = auth.Authenticator.Authenticate(username, password)
+		}
+		if err != nil || user == nil || user.FullName == "" {
+			c.JSON(401, _type.FaildResult(errors.New("invalid credentials")))
+			return
+		}
+	}
+
+	var (
+		repos []string
+		err   error
+	)
+	if owner := c.Query("code"); owner != "" {
+		repos, err = GetRepos(owner)
+	} else {
+		repos, err = getAllRepos()
+	}
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+
+	maxResults := conf.Search.MaxResults
+	result := codeSearchResult{Matches: make([]codeMatch, 0)}
+	authorized := make(map[string]bool) // By owner
+	for _, r := range repos {
+		repoLink := strings.TrimSuffix(r, ".git")
+		meta, err := GetRepoMeta(repoLink)
+		if err != nil {
+			c.JSON(500, _type.FaildResult(err))
+			return
+		}
+		if meta.IsPrivate {
+			owner := strings.SplitN(repoLink, "/", 2)[0]
+			ok, checked := authorized[owner]
+			if !checked && user != nil {
+				ok, _ = auth.Authorizer.Authorize(user, owner)
+				authorized[owner] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		idx, err := loadIndex(repoLink)
+		if err != nil {
+			log.Error("Failed to get index of %q: %v", repoLink, err)
+			continue
+		} else if idx == nil {
+			continue
+		}
+		opts := search.Options{
+			Query:    query,
+			IsRegexp: c.QueryBool("regexp"),
+			Path:     c.Query("path"),
+			MaxFiles: conf.Search.MaxSearchedFiles,
+		}
+		if maxResults > 0 {
+			// Ask for one more to tell whether there are more matches.
+			opts.MaxResults = maxResults - len(result.Matches) + 1
+		}
+		reader := &blobBatchReader{repoLink: repoLink}
+		matches, err := idx.Search(opts, reader.Read)
+		if cerr := reader.Close(); cerr != nil {
+			log.Error("Failed to stop reading blobs of %q: %v", repoLink, cerr)
+		}
+		if err == search.ErrTooManyFiles {
+			c.JSON(400, _type.FaildResult(errors.New("query matches too many files, try a longer one or a path")))
+			return
+		} else if err != nil {
+			c.JSON(500, _type.FaildResult(err))
+			return
+		}
+		for _, m := range matches {
+			if maxResults > 0 && len(result.Matches) >= maxResults {
+				result.IsTruncated = true
+				break
+			}
+			result.Matches = append(result.Matches, codeMatch{Repo: repoLink, Match: m})
+		}
+		if result.IsTruncated {
+			break
+		}
+	}
+	c.JSON(200, _type.SuccessResult(result))
+}
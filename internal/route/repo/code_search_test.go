@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+)
+
+func TestSearchCode(t *testing.T) {
+	setupTestRoot(t)
+	oldServer, oldSearch := conf.Server, conf.Search
+	conf.Server.AppDataPath = t.TempDir()
+	defer func() {
+		conf.Server, conf.Search = oldServer, oldSearch
+	}()
+
+	newTestRepo(t, "alice/public")
+	newTestRepo(t, "alice/private")
+	require.NoError(t, UpdateRepoMeta("alice/public", func(meta *RepoMeta) {
+		meta.IsPrivate = false
+	}))
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/public"), ".")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "main.go"), []byte("package main\n\n// test entry\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "logo.png"), []byte("\x89PNG\x00test"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add files")
+	runGit(t, workDir, "push", "origin", "master")
+
+	for _, repoLink := range []string{"alice/public", "alice/private", "alice/missing"} {
+		require.NoError(t, IndexRepository(repoLink))
+	}
+	idx, err := loadIndex("alice/public")
+	require.NoError(t, err)
+	require.NotNil(t, idx)
+	assert.Len(t, idx.Files, 2) // Binary files are skipped
+	for _, f := range idx.Files {
+		assert.Empty(t, f.Content, "contents are read from blobs")
+		assert.Len(t, f.BlobID, 40)
+	}
+	idx, err = loadIndex("alice/missing")
+	require.NoError(t, err)
+	assert.Nil(t, idx)
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Get("/repos/search/code", SearchCode)
+
+	search := func(query string) []codeMatch {
+		req, err := http.NewRequest("GET", "/repos/search/code?"+query, nil)
+		require.NoError(t, err)
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		var result struct {
+			Data codeSearchResult
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+		return result.Data.Matches
+	}
+
+	// Private repositories are not searched without credentials.
+	matches := search("q=test")
+	require.Len(t, matches, 2)
+	assert.Equal(t, "alice/public", matches[0].Repo)
+	assert.Equal(t, "README.md", matches[0].Path)
+	assert.Equal(t, "main.go", matches[1].Path)
+	assert.Equal(t, 3, matches[1].Line)
+
+	matches = search("q=test&path=*.go")
+	require.Len(t, matches, 1)
+	assert.Equal(t, "main.go", matches[0].Path)
+
+	matches = search("q=%5Epackage&regexp=true")
+	require.Len(t, matches, 1)
+	assert.Equal(t, "package main", matches[0].Content)
+
+	conf.Search.MaxResults = 1
+	assert.Len(t, search("q=test"), 1)
+
+	// Queries too broad to use the index are rejected.
+	for _, query := range []string{"q=te", "q=test"} {
+		conf.Search.MaxSearchedFiles = 1
+		req, err := http.NewRequest("GET", "/repos/search/code?"+query, nil)
+		require.NoError(t, err)
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+}
+
+func TestIndexCache(t *testing.T) {
+	setupTestRoot(t)
+	oldServer, oldSearch := conf.Server, conf.Search
+	conf.Server.AppDataPath = t.TempDir()
+	conf.Search.MaxCachedIndexes = 2
+	defer func() {
+		conf.Server, conf.Search = oldServer, oldSearch
+	}()
+
+	for _, repoLink := range []string{"alice/a", "alice/b", "alice/c"} {
+		newTestRepo(t, repoLink)
+		require.NoError(t, IndexRepository(repoLink))
+	}
+	// The least recently used index is dropped beyond the max number.
+	assert.Nil(t, getCachedIndex("alice/a"))
+	assert.NotNil(t, getCachedIndex("alice/b"))
+	assert.NotNil(t, getCachedIndex("alice/c"))
+
+	_, err := loadIndex("alice/a")
+	require.NoError(t, err)
+	assert.NotNil(t, getCachedIndex("alice/a"))
+	assert.Nil(t, getCachedIndex("alice/b"))
+
+	// Removed indexes are dropped from the cache.
+	RemoveIndex("alice/c")
+	assert.Nil(t, getCachedIndex("alice/c"))
+	idx, err := loadIndex("alice/c")
+	require.NoError(t, err)
+	assert.Nil(t, idx)
+}
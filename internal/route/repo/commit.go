@@ -10,7 +10,7 @@ import (
 	"git-server/internal/type"
 	"github.com/gogs/git-module"
 	"path"
-	"time"
+	"strconv"
 )
 
 type DiffInfo struct {
@@ -34,10 +34,21 @@ func Diff(c *context.Context) {
 		return
 	}
 
-	diff, err := gitutil.RepoDiff(c.Repo.GitRepo,
-		commitID, conf.Git.MaxDiffFiles, conf.Git.MaxDiffLines, conf.Git.MaxDiffLineChars,
-		git.DiffOptions{Timeout: time.Duration(conf.Git.Timeout.Diff) * time.Second},
-	)
+	opts, err := queryDiffOptions(c)
+	if err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	from, err := diffBase(commit)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	diff, err := getDiff(c.Repo.GitRepo.Path(), from, commit.ID.String(), queryDiffLimits(c, defaultDiffLimits()), opts)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(errors.New(fmt.Sprintf("%v %s", err, "get diff"))))
+		return
+	}
 
 	change := Change{
 		TotalAdditions: diff.TotalAdditions(),
@@ -50,11 +61,6 @@ func Diff(c *context.Context) {
 		Files:   diff.Files,
 	}
 
-	if err != nil {
-		c.JSON(500, _type.FaildResult(errors.New(fmt.Sprintf("%v %s", err, "get diff"))))
-		return
-	}
-
 	parents := make([]string, commit.ParentsCount())
 	for i := 0; i < commit.ParentsCount(); i++ {
 		sha, err := commit.ParentID(i)
@@ -144,16 +150,43 @@ func RenderIssueLinks(oldCommits []*git.Commit, _ string) []*git.Commit {
 	return oldCommits
 }
 
+// SearchCommits searches commits by message in "q", and by filters of "author",
+// "committer", "since", "until", "path" and "merges", with stats of changed files.
 func SearchCommits(c *context.Context) {
 	c.Data["PageIsCommits"] = true
 
-	keyword := c.Query("q")
-	if keyword == "" {
+	opts := CommitSearchOptions{
+		Keyword:   c.Query("q"),
+		Author:    c.Query("author"),
+		Committer: c.Query("committer"),
+		Path:      c.Query("path"),
+		Page:      c.QueryInt("page"),
+		PageSize:  c.QueryInt("pageSize"),
+	}
+	var err error
+	if opts.Since, err = parseSearchTime(c.Query("since")); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	if opts.Until, err = parseSearchTime(c.Query("until")); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	if merges := c.Query("merges"); merges != "" {
+		onlyMerges, err := strconv.ParseBool(merges)
+		if err != nil {
+			c.JSON(500, _type.FaildResult(fmt.Errorf("invalid merges %q", merges)))
+			return
+		}
+		opts.Merges = &onlyMerges
+	}
+	if opts.Keyword == "" && opts.Author == "" && opts.Committer == "" && opts.Path == "" &&
+		opts.Since.IsZero() && opts.Until.IsZero() && opts.Merges == nil {
 		c.Redirect(c.Repo.RepoLink + "/commits/" + c.Repo.BranchName)
 		return
 	}
 
-	commits, err := c.Repo.Commit.SearchCommits(keyword)
+	commits, err := SearchCommitsByOptions(c.Repo.GitRepo, c.Repo.CommitID, opts)
 	if err != nil {
 		c.JSON(500, _type.FaildResult(errors.New("search commits")))
 		return
@@ -162,7 +195,14 @@ func SearchCommits(c *context.Context) {
 	type Commit map[string]interface{}
 	Commits := make([]Commit, 0)
 	for i := 0; i < len(commits); i++ {
-		Commits = append(Commits, _type.ProduceLastCommit(commits[i]))
+		stats, err := GetCommitStats(c.Repo.GitRepo.Path(), commits[i])
+		if err != nil {
+			c.JSON(500, _type.FaildResult(err))
+			return
+		}
+		commit := _type.ProduceLastCommit(commits[i])
+		commit["Stats"] = stats
+		Commits = append(Commits, commit)
 	}
 	c.JSON(200, _type.SuccessResult(Commits))
 
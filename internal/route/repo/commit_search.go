@@ -0,0 +1,211 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+
+	"git-server/internal/conf"
+	processed "git-server/internal/process"
+)
+
+// maxCommitSearchPageSize is the max number of commits in a page of a commit
+// search, each of which is diffed for its stats.
+const maxCommitSearchPageSize = 100
+
+// CommitSearchOptions contains filters of a commit search, empty ones are ignored.
+type CommitSearchOptions struct {
+	Keyword   string // Regular expression matching commit messages, case-insensitively
+	Author    string // Regular expression matching names or emails of authors
+	Committer string // Regular expression matching names or emails of committers
+	Since     time.Time
+	Until     time.Time
+	Path      string
+	// Whether to only return merge commits if true, or exclude them if false.
+	Merges *bool
+
+	Page     int // 1-based
+	PageSize int
+}
+
+// FileStat is the status and numbers of changed lines of a file.
+type FileStat struct {
+	Name    string
+	OldName string `json:",omitempty"` // The name before renamed or copied
+	// One of "added", "modified", "deleted", "renamed", "copied" and "type-changed".
+	Status    string
+	Additions int
+	Deletions int
+	IsBinary  bool
+}
+
+// diffStatuses maps status letters of "git diff --raw" to statuses of files.
+var diffStatuses = map[byte]string{
+	'A': "added",
+	'M': "modified",
+	'D': "deleted",
+	'R': "renamed",
+	'C': "copied",
+	'T': "type-changed",
+}
+
+// CommitStats is the numbers of changed lines of a commit compared to its first parent.
+type CommitStats struct {
+	Additions int
+	Deletions int
+	Files     []FileStat
+}
+
+// SearchCommitsByOptions returns a page of commits reachable from the revision
+// that match the options, in reverse chronological order. The page size is
+// capped by maxCommitSearchPageSize.
+func SearchCommitsByOptions(gitRepo *git.Repository, rev string, opts CommitSearchOptions) ([]*git.Commit, error) {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.PageSize < 1 {
+		opts.PageSize = 5
+	} else if opts.PageSize > maxCommitSearchPageSize {
+		opts.PageSize = maxCommitSearchPageSize
+	}
+
+	var args []string
+	if opts.Author != "" {
+		args = append(args, "--author="+opts.Author)
+	}
+	if opts.Committer != "" {
+		args = append(args, "--committer="+opts.Committer)
+	}
+	if !opts.Until.IsZero() {
+		args = append(args, "--until="+opts.Until.Format(time.RFC3339))
+	}
+	if opts.Merges != nil {
+		if *opts.Merges {
+			args = append(args, "--merges")
+		} else {
+			args = append(args, "--no-merges")
+		}
+	}
+
+	return gitRepo.Log(rev, git.LogOptions{
+		MaxCount:         opts.PageSize,
+		Skip:             (opts.Page - 1) * opts.PageSize,
+		Since:            opts.Since,
+		GrepPattern:      opts.Keyword,
+		RegexpIgnoreCase: true,
+		Path:             opts.Path,
+		Timeout:          gitTimeout(conf.Git.Timeout.Diff),
+		CommandOptions:   git.CommandOptions{Args: args},
+	})
+}
+
+// GetCommitStats returns changed lines of each file of the commit compared to
+// its first parent.
+func GetCommitStats(repoPath string, commit *git.Commit) (*CommitStats, error) {
+	revs := []string{commit.ID.String()}
+	if commit.ParentsCount() > 0 {
+		parent, err := commit.ParentID(0)
+		if err != nil {
+			return nil, err
+		}
+		revs = []string{parent.String(), commit.ID.String()}
+	}
+	return getDiffStats(repoPath, DiffOptions{}, revs...)
+}
+
+// getDiffStats returns changed files between two commits, or of the commit
+// compared to its first parent if only one is given, with renames detected.
+// Only the rename threshold of the options applies.
+func getDiffStats(repoPath string, opts DiffOptions, revs ...string) (*CommitStats, error) {
+	args := append([]string{"diff-tree", "-r", "-z", opts.renameArg(), "--raw", "--numstat", "--no-commit-id", "--root"}, revs...)
+	stdout, stderr, err := processed.ExecDir(gitTimeout(conf.Git.Timeout.Diff), repoPath,
+		fmt.Sprintf("getDiffStats (git diff-tree): %s", strings.Join(revs, " ")),
+		"git", args...)
+	if err != nil {
+		return nil, fmt.Errorf("git diff-tree: %v - %s", err, stderr)
+	}
+	return parseDiffStats(stdout), nil
+}
+
+// parseDiffStats parses the output of "git diff-tree -z --raw --numstat", in
+// which records of all files in the raw format come before those in the
+// numstat format in the same order.
+func parseDiffStats(stdout string) *CommitStats {
+	stats := &CommitStats{Files: make([]FileStat, 0)}
+	fields := strings.Split(stdout, "\x00")
+	next := func() string {
+		if len(fields) == 0 {
+			return ""
+		}
+		f := fields[0]
+		fields = fields[1:]
+		return f
+	}
+
+	n := 0 // Index of the file of the next numstat record
+	for len(fields) > 0 {
+		field := next()
+		if strings.HasPrefix(field, ":") {
+			// ":<old mode> <new mode> <old blob> <new blob> <status>" followed by
+			// the name, or the old and the new names if renamed or copied.
+			meta := strings.Fields(field)
+			if len(meta) != 5 || meta[4] == "" {
+				continue
+			}
+			f := FileStat{Name: next(), Status: diffStatuses[meta[4][0]]}
+			if f.Status == "" {
+				f.Status = "modified"
+			}
+			if meta[4][0] == 'R' || meta[4][0] == 'C' {
+				f.OldName, f.Name = f.Name, next()
+			}
+			stats.Files = append(stats.Files, f)
+			continue
+		}
+
+		// "<additions>\t<deletions>\t<name>", or with an empty name followed by
+		// the old and the new names if renamed or copied. Numbers are "-" for
+		// binary files.
+		parts := strings.SplitN(field, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[2] == "" {
+			next()
+			next()
+		}
+		if n >= len(stats.Files) {
+			continue
+		}
+		f := &stats.Files[n]
+		n++
+		if parts[0] == "-" {
+			f.IsBinary = true
+		} else {
+			f.Additions, _ = strconv.Atoi(parts[0])
+			f.Deletions, _ = strconv.Atoi(parts[1])
+		}
+		stats.Additions += f.Additions
+		stats.Deletions += f.Deletions
+	}
+	return stats
+}
+
+// parseSearchTime parses a time in RFC 3339 or "YYYY-MM-DD" format.
+func parseSearchTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchCommitsByOptions(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	commit := func(author, date, file, content, message string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(workDir, file)), os.ModePerm))
+		require.NoError(t, os.WriteFile(filepath.Join(workDir, file), []byte(content), 0644))
+		runGit(t, workDir, "add", "--all")
+		cmd := exec.Command("git", "commit", "-m", message, "--author", author+" <"+author+"@example.com>", "--date", date)
+		cmd.Dir = workDir
+		cmd.Env = append(os.Environ(),
+			"GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com",
+			"GIT_COMMITTER_DATE="+date,
+		)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, "%s", out)
+	}
+	commit("bob", "2023-01-01T00:00:00Z", "docs/a.md", "a\n", "docs: add a")
+	commit("carol", "2023-02-01T00:00:00Z", "main.go", "package main\n", "add main")
+	commit("bob", "2023-03-01T00:00:00Z", "docs/a.md", "a\nb\nc\n", "docs: update a")
+	runGit(t, workDir, "checkout", "-b", "feature", "HEAD~1")
+	commit("carol", "2023-03-15T00:00:00Z", "feature.go", "package main\n", "add feature")
+	runGit(t, workDir, "checkout", "master")
+	runGit(t, workDir, "merge", "--no-ff", "-m", "merge feature", "feature")
+	runGit(t, workDir, "push", "origin", "master")
+
+	gitRepo, err := git.Open(repoPath("alice/repo"))
+	require.NoError(t, err)
+
+	messages := func(opts CommitSearchOptions) []string {
+		commits, err := SearchCommitsByOptions(gitRepo, "master", opts)
+		require.NoError(t, err)
+		var got []string
+		for _, c := range commits {
+			got = append(got, c.Summary())
+		}
+		return got
+	}
+
+	assert.Equal(t, []string{"docs: update a", "docs: add a"},
+		messages(CommitSearchOptions{Author: "bob", Path: "docs/", PageSize: 10}))
+	assert.Equal(t, []string{"docs: update a"},
+		messages(CommitSearchOptions{Author: "bob", Keyword: "UPDATE", PageSize: 10}))
+	assert.Equal(t, []string{"docs: update a", "add main"},
+		messages(CommitSearchOptions{
+			Since:    time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC),
+			Until:    time.Date(2023, 3, 10, 0, 0, 0, 0, time.UTC),
+			Merges:   new(bool),
+			PageSize: 10,
+		}))
+	onlyMerges := true
+	assert.Equal(t, []string{"merge feature"},
+		messages(CommitSearchOptions{Merges: &onlyMerges, PageSize: 10}))
+	assert.Equal(t, []string{"add feature"},
+		messages(CommitSearchOptions{Committer: "tester@example.com", Author: "carol", Page: 1, PageSize: 1}))
+	assert.Equal(t, []string{"add main"},
+		messages(CommitSearchOptions{Committer: "tester@example.com", Author: "carol", Page: 2, PageSize: 1}))
+
+	head, err := gitRepo.BranchCommit("master")
+	require.NoError(t, err)
+	docs, err := head.Parent(0)
+	require.NoError(t, err)
+	stats, err := GetCommitStats(repoPath("alice/repo"), docs)
+	require.NoError(t, err)
+	assert.Equal(t, &CommitStats{
+		Additions: 2,
+		Files:     []FileStat{{Name: "docs/a.md", Status: "modified", Additions: 2}},
+	}, stats)
+
+	// Merge commits are compared to the first parent.
+	stats, err = GetCommitStats(repoPath("alice/repo"), head)
+	require.NoError(t, err)
+	assert.Equal(t, []FileStat{{Name: "feature.go", Status: "added", Additions: 1}}, stats.Files)
+}
+
+func TestSearchCommitsByOptions_MaxPageSize(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	for i := 0; i <= maxCommitSearchPageSize; i++ {
+		runGit(t, workDir, "commit", "--allow-empty", "-m", "commit")
+	}
+	runGit(t, workDir, "push", "origin", "master")
+
+	gitRepo, err := git.Open(repoPath("alice/repo"))
+	require.NoError(t, err)
+	commits, err := SearchCommitsByOptions(gitRepo, "master", CommitSearchOptions{Keyword: "commit", PageSize: 100000})
+	require.NoError(t, err)
+	assert.Len(t, commits, maxCommitSearchPageSize)
+}
@@ -0,0 +1,279 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogs/git-module"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	processed "git-server/internal/process"
+	"git-server/internal/tool"
+	"git-server/internal/type"
+)
+
+// CompareOptions contains arguments of a comparison.
+type CompareOptions struct {
+	Page     int // 1-based, of commits
+	PageSize int
+	// Whether to include the parsed diff, which is truncated by Limits.
+	WithDiff bool
+	Limits   DiffLimits
+	Diff     DiffOptions
+}
+
+// Comparison is the result of comparing two revisions.
+type Comparison struct {
+	BaseCommitID string
+	HeadCommitID string
+	IsThreeDot   bool
+	// The commit the diff starts from, which is the merge base of the revisions
+	// for three-dot comparisons and the base otherwise.
+	FromCommitID string
+	TotalCommits int64
+	Commits      []map[string]interface{}
+	Stats        *CommitStats
+	Diff         *DiffInfo `json:",omitempty"`
+}
+
+// ErrInvalidArgument is returned when an argument of the request is invalid.
+type ErrInvalidArgument struct {
+	Reason string
+}
+
+func IsErrInvalidArgument(err error) bool {
+	_, ok := err.(ErrInvalidArgument)
+	return ok
+}
+
+func (err ErrInvalidArgument) Error() string {
+	return err.Reason
+}
+
+// parseCompareRange parses a range in the form of "<base>..<head>" or
+// "<base>...<head>".
+func parseCompareRange(spec string) (base, head string, isThreeDot bool, err error) {
+	if i := strings.Index(spec, "..."); i >= 0 {
+		base, head, isThreeDot = spec[:i], spec[i+3:], true
+	} else if i = strings.Index(spec, ".."); i >= 0 {
+		base, head = spec[:i], spec[i+2:]
+	} else {
+		return "", "", false, ErrInvalidArgument{fmt.Sprintf("invalid range %q", spec)}
+	}
+	if base == "" || head == "" {
+		return "", "", false, ErrInvalidArgument{fmt.Sprintf("invalid range %q", spec)}
+	}
+	return base, head, isThreeDot, nil
+}
+
+// resolveCommit returns the ID of the commit that the revision (e.g. a SHA,
+// tag, branch or "HEAD~3") points to.
+func resolveCommit(repoPath, rev string) (string, error) {
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return "", ErrInvalidArgument{fmt.Sprintf("invalid revision %q", rev)}
+	}
+	stdout, _, err := processed.ExecDir(-1, repoPath,
+		fmt.Sprintf("resolveCommit (git rev-parse): %s", repoPath),
+		"git", "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", ErrInvalidArgument{fmt.Sprintf("revision %q does not exist", rev)}
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// resolveCompareRange resolves revisions of the range and returns IDs of the
+// base and head commits, and of the commit the diff starts from.
+func resolveCompareRange(repoPath, spec string) (base, head, from string, isThreeDot bool, err error) {
+	baseRev, headRev, isThreeDot, err := parseCompareRange(spec)
+	if err != nil {
+		return "", "", "", false, err
+	}
+	if base, err = resolveCommit(repoPath, baseRev); err != nil {
+		return "", "", "", false, err
+	}
+	if head, err = resolveCommit(repoPath, headRev); err != nil {
+		return "", "", "", false, err
+	}
+
+	from = base
+	if isThreeDot {
+		stdout, _, err := processed.ExecDir(-1, repoPath,
+			fmt.Sprintf("resolveCompareRange (git merge-base): %s", repoPath),
+			"git", "merge-base", base, head)
+		if err != nil {
+			return "", "", "", false, ErrInvalidArgument{fmt.Sprintf("%q and %q have no merge base", baseRev, headRev)}
+		}
+		from = strings.TrimSpace(stdout)
+	}
+	return base, head, from, isThreeDot, nil
+}
+
+// CompareRevisions compares revisions of the range. Commits are those reachable
+// from the head but not the base in both forms, while the diff is between the
+// revisions for "<base>..<head>", and between their merge base and the head
+// for "<base>...<head>".
+func CompareRevisions(gitRepo *git.Repository, spec string, opts CompareOptions) (*Comparison, error) {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.PageSize < 1 {
+		opts.PageSize = 5
+	}
+
+	base, head, from, isThreeDot, err := resolveCompareRange(gitRepo.Path(), spec)
+	if err != nil {
+		return nil, err
+	}
+	cmp := &Comparison{
+		BaseCommitID: base,
+		HeadCommitID: head,
+		IsThreeDot:   isThreeDot,
+		FromCommitID: from,
+		Commits:      make([]map[string]interface{}, 0),
+	}
+
+	timeout := time.Duration(conf.Git.Timeout.Diff) * time.Second
+	if cmp.TotalCommits, err = gitRepo.RevListCount([]string{base + ".." + head},
+		git.RevListCountOptions{Timeout: timeout}); err != nil {
+		return nil, fmt.Errorf("count commits: %v", err)
+	}
+	commits, err := gitRepo.Log(base+".."+head, git.LogOptions{
+		MaxCount: opts.PageSize,
+		Skip:     (opts.Page - 1) * opts.PageSize,
+		Timeout:  timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list commits: %v", err)
+	}
+	for _, c := range commits {
+		cmp.Commits = append(cmp.Commits, _type.ProduceLastCommit(c))
+	}
+
+	if cmp.Stats, err = getDiffStats(gitRepo.Path(), opts.Diff, from, head); err != nil {
+		return nil, err
+	}
+
+	if opts.WithDiff {
+		diff, err := getDiff(gitRepo.Path(), from, head, opts.Limits, opts.Diff)
+		if err != nil {
+			return nil, err
+		}
+		cmp.Diff = &DiffInfo{
+			Changes: Change{
+				TotalAdditions: diff.TotalAdditions(),
+				TotalDeletions: diff.TotalDeletions(),
+				IsIncomplete:   diff.IsIncomplete(),
+			},
+			Files: diff.Files,
+		}
+	}
+	return cmp, nil
+}
+
+// rawDiffArgs returns arguments of git to produce the untruncated diff between
+// the commits, or patches of commits reachable from the head but not the base
+// in the mbox format of "git format-patch" if format is "patch".
+func rawDiffArgs(format, from, head string) ([]string, error) {
+	switch format {
+	case "diff":
+		return []string{"diff", "--binary", "--full-index", from, head}, nil
+	case "patch":
+		return []string{"format-patch", "--stdout", "--binary", "--full-index", from + ".." + head}, nil
+	}
+	return nil, ErrInvalidArgument{fmt.Sprintf("invalid format %q", format)}
+}
+
+// gitOutputWriter writes the response of a text file to download, where the
+// status and headers are written along with the first output.
+type gitOutputWriter struct {
+	c        *context.Context
+	filename string
+	started  bool
+}
+
+func (w *gitOutputWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.c.Resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.c.Resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.filename))
+	w.c.Resp.WriteHeader(200)
+}
+
+func (w *gitOutputWriter) Write(p []byte) (int, error) {
+	w.start()
+	return w.c.Resp.Write(p)
+}
+
+// serveGitOutput streams output of the git command in the repository as a
+// text file to download. It responds with an error if the command fails
+// before any output.
+func serveGitOutput(c *context.Context, repoPath, filename string, args ...string) {
+	w := &gitOutputWriter{c: c, filename: filename}
+	stderr, err := processed.ExecDirWriter(gitTimeout(conf.Git.Timeout.Diff), repoPath,
+		fmt.Sprintf("serveGitOutput (git %s): %s", args[0], repoPath),
+		w, "git", args...)
+	if err == nil {
+		// Output may be empty, e.g. the diff of identical commits.
+		w.start()
+		return
+	} else if !w.started {
+		c.JSON(500, _type.FaildResult(fmt.Errorf("git %s: %v - %s", args[0], err, stderr)))
+		return
+	}
+	// Output has started, so the error can only be logged.
+	log.Error("Failed to serve output of git %s [repo: %s]: %v - %s", strings.Join(args, " "), repoPath, err, stderr)
+}
+
+// Compare compares revisions of the range in "range", e.g. "v1.0...master" or
+// "HEAD~3..HEAD". The parsed diff is included if "diff" is true, and the raw
+// diff or patches are downloaded instead if "format" is "diff" or "patch".
+// Options of the diff are those of queryDiffOptions.
+func Compare(c *context.Context) {
+	spec := c.Query("range")
+	if format := c.Query("format"); format != "" {
+		_, head, from, _, err := resolveCompareRange(c.Repo.GitRepo.Path(), spec)
+		if err != nil {
+			if IsErrInvalidArgument(err) {
+				c.JSON(400, _type.FaildResult(err))
+				return
+			}
+			c.JSON(500, _type.FaildResult(err))
+			return
+		}
+		args, err := rawDiffArgs(format, from, head)
+		if err != nil {
+			c.JSON(400, _type.FaildResult(err))
+			return
+		}
+		serveGitOutput(c, c.Repo.GitRepo.Path(),
+			tool.ShortSHA1(from)+"..."+tool.ShortSHA1(head)+"."+format, args...)
+		return
+	}
+
+	diffOpts, err := queryDiffOptions(c)
+	if err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	cmp, err := CompareRevisions(c.Repo.GitRepo, spec, CompareOptions{
+		Page:     c.QueryInt("page"),
+		PageSize: c.QueryInt("pageSize"),
+		WithDiff: c.QueryBool("diff"),
+		Limits:   queryDiffLimits(c, defaultDiffLimits()),
+		Diff:     diffOpts,
+	})
+	if err != nil {
+		if IsErrInvalidArgument(err) {
+			c.JSON(400, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(cmp))
+}
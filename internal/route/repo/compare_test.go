@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/context"
+)
+
+func TestParseCompareRange(t *testing.T) {
+	tests := []struct {
+		spec           string
+		wantBase       string
+		wantHead       string
+		wantIsThreeDot bool
+		wantErr        bool
+	}{
+		{spec: "master...feature", wantBase: "master", wantHead: "feature", wantIsThreeDot: true},
+		{spec: "HEAD~3..HEAD", wantBase: "HEAD~3", wantHead: "HEAD"},
+		{spec: "v1.0..feature/x", wantBase: "v1.0", wantHead: "feature/x"},
+		{spec: "master", wantErr: true},
+		{spec: "...master", wantErr: true},
+		{spec: "master..", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			base, head, isThreeDot, err := parseCompareRange(test.spec)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantBase, base)
+			assert.Equal(t, test.wantHead, head)
+			assert.Equal(t, test.wantIsThreeDot, isThreeDot)
+		})
+	}
+}
+
+func TestCompareRevisions(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	repoPath := repoPath("alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath, ".")
+	runGit(t, workDir, "checkout", "-b", "feature")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "feature.txt"), []byte("a\nb\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add feature")
+	runGit(t, workDir, "checkout", "master")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "README.md"), []byte("# test\nmore\n"), 0644))
+	runGit(t, workDir, "commit", "-am", "update readme")
+	runGit(t, workDir, "push", "origin", "master", "feature")
+
+	gitRepo, err := git.Open(repoPath)
+	require.NoError(t, err)
+
+	// Three-dot compares the head with the merge base, so changes of the base are excluded.
+	cmp, err := CompareRevisions(gitRepo, "master...feature", CompareOptions{WithDiff: true})
+	require.NoError(t, err)
+	assert.True(t, cmp.IsThreeDot)
+	assert.Equal(t, strings.TrimSpace(runGit(t, repoPath, "merge-base", "master", "feature")), cmp.FromCommitID)
+	assert.EqualValues(t, 1, cmp.TotalCommits)
+	require.Len(t, cmp.Commits, 1)
+	assert.Equal(t, cmp.HeadCommitID, cmp.Commits[0]["ID"])
+	assert.Equal(t, []FileStat{{Name: "feature.txt", Status: "added", Additions: 2}}, cmp.Stats.Files)
+	require.NotNil(t, cmp.Diff)
+	assert.Equal(t, 2, cmp.Diff.Changes.TotalAdditions)
+
+	// Two-dot compares the revisions directly.
+	cmp, err = CompareRevisions(gitRepo, "master..feature", CompareOptions{})
+	require.NoError(t, err)
+	assert.False(t, cmp.IsThreeDot)
+	assert.Equal(t, cmp.BaseCommitID, cmp.FromCommitID)
+	assert.EqualValues(t, 1, cmp.TotalCommits)
+	assert.Len(t, cmp.Stats.Files, 2)
+	assert.Nil(t, cmp.Diff)
+
+	cmp, err = CompareRevisions(gitRepo, "feature~1..feature", CompareOptions{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, cmp.TotalCommits)
+
+	_, err = CompareRevisions(gitRepo, "master...missing", CompareOptions{})
+	assert.True(t, IsErrInvalidArgument(err))
+	_, err = CompareRevisions(gitRepo, "--output=x..master", CompareOptions{})
+	assert.True(t, IsErrInvalidArgument(err))
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Get("/compare", func(c *context.Context) {
+		c.Repo.GitRepo = gitRepo
+	}, Compare)
+
+	for _, format := range []string{"diff", "patch"} {
+		t.Run(format, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/compare?range=master...feature&format="+format, nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Contains(t, resp.Body.String(), "+++ b/feature.txt")
+			assert.NotContains(t, resp.Body.String(), "README.md")
+			if format == "patch" {
+				assert.Contains(t, resp.Body.String(), "Subject: [PATCH] add feature")
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"range=master",
+		"range=master...missing",
+		"range=master...feature&format=zip",
+		"range=master...feature&ignoreWhitespace=some",
+	} {
+		t.Run(query, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/compare?"+query, nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+
+	"git-server/internal/conf"
+	processed "git-server/internal/process"
+	"git-server/internal/repoutil"
+)
+
+type CreateRepoOptions struct {
+	Owner       string
+	Name        string
+	Description string
+	Website     string
+	// Whether anonymous users can read the repository, which is private by default.
+	IsPublic bool
+	// The name of the default branch, the one of the configuration is used if empty.
+	DefaultBranch string
+	// Whether to make an initial commit with README, .gitignore and license.
+	AutoInit   bool
+	Readme     string
+	Gitignores string // Comma separated
+	License    string
+	// The full name (i.e. "<owner>/<name>") of the repository whose tree of the
+	// default branch is copied as the initial commit.
+	TemplateRepo string
+}
+
+// initialFiles returns files of the initial commit generated by the options,
+// mapped from the path in the tree to the content.
+func (opts CreateRepoOptions) initialFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if !opts.AutoInit {
+		return files, nil
+	}
+
+	readme := opts.Readme
+	if readme == "" {
+		readme = "Default"
+	}
+	data, err := conf.Asset(conf.AssetReadme, readme)
+	if err != nil {
+		return nil, err
+	}
+	files["README.md"] = []byte(strings.NewReplacer(
+		"{Name}", opts.Name,
+		"{Description}", opts.Description,
+	).Replace(string(data)))
+
+	if opts.Gitignores != "" {
+		var buf bytes.Buffer
+		for _, name := range strings.Split(opts.Gitignores, ",") {
+			name = strings.TrimSpace(name)
+			data, err = conf.Asset(conf.AssetGitignore, name)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString("# ---> " + name + "\n")
+			buf.Write(data)
+			buf.WriteString("\n")
+		}
+		files[".gitignore"] = buf.Bytes()
+	}
+
+	if opts.License != "" {
+		data, err = conf.Asset(conf.AssetLicense, opts.License)
+		if err != nil {
+			return nil, err
+		}
+		files["LICENSE"] = []byte(strings.NewReplacer(
+			"[year]", strconv.Itoa(time.Now().Year()),
+			"[fullname]", opts.Owner,
+		).Replace(string(data)))
+	}
+	return files, nil
+}
+
+// CreateRepository creates a new repository, which is initialized with a commit
+// if either files to be generated or a template repository is given.
+func CreateRepository(opts CreateRepoOptions) (err error) {
+	repoLink := repoutil.FullRepoName(opts.Owner, opts.Name)
+	repoPath := repoPath(repoLink)
+	if repoExists(repoPath) {
+		return errors.Errorf("repository %q already exists", repoLink)
+	}
+
+	// Validate all options before anything is created.
+	branch := opts.DefaultBranch
+	if branch == "" {
+		branch = conf.Repository.DefaultBranch
+	}
+	if branch != "" {
+		if _, _, err = processed.Exec(fmt.Sprintf("CreateRepository (git check-ref-format): %s", branch),
+			"git", "check-ref-format", "--branch", branch); err != nil {
+			return errors.Errorf("invalid branch name %q", branch)
+		}
+	}
+	files, err := opts.initialFiles()
+	if err != nil {
+		return err
+	}
+	if opts.TemplateRepo != "" && !repoExists(repoPathOf(opts.TemplateRepo)) {
+		return errors.Errorf("template repository %q does not exist", opts.TemplateRepo)
+	}
+
+	if err = initRepo(repoLink); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(repoPath)
+		}
+	}()
+
+	if branch != "" {
+		if _, stderr, err := processed.ExecDir(-1, repoPath,
+			fmt.Sprintf("CreateRepository (git symbolic-ref): %s", repoLink),
+			"git", "symbolic-ref", "HEAD", git.RefsHeads+branch); err != nil {
+			return fmt.Errorf("git symbolic-ref: %v - %s", err, stderr)
+		}
+	}
+
+	if err = UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		meta.Description = opts.Description
+		meta.Website = opts.Website
+		meta.IsPrivate = !opts.IsPublic
+		meta.Created = time.Now()
+	}); err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	}
+
+	if len(files) == 0 && opts.TemplateRepo == "" {
+		return nil
+	}
+	if err = initRepoCommit(repoPath, opts, files); err != nil {
+		return fmt.Errorf("initRepoCommit: %v", err)
+	}
+	return nil
+}
+
+// repoPathOf is like repoPath but accepts the full name with an optional ".git" suffix.
+func repoPathOf(fullName string) string {
+	return repoPath(strings.TrimSuffix(fullName, ".git"))
+}
+
+// initRepoCommit makes the initial commit of the repository in a temporary
+// directory with the tree of the template repository and generated files, and
+// pushes it to the default branch.
+func initRepoCommit(repoPath string, opts CreateRepoOptions, files map[string][]byte) (err error) {
+	tmpPath, err := createTempDir("init-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpPath)
+	}()
+
+	if opts.TemplateRepo != "" {
+		if err = extractTemplate(repoPathOf(opts.TemplateRepo), tmpPath); err != nil {
+			return fmt.Errorf("extract template: %v", err)
+		}
+	}
+	for name, data := range files {
+		p := filepath.Join(tmpPath, name)
+		// Files from the template repository take precedence.
+		if _, err := os.Lstat(p); err == nil {
+			continue
+		}
+		if err = os.WriteFile(p, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	branch, err := git.SymbolicRef(repoPath)
+	if err != nil {
+		return fmt.Errorf("get default branch: %v", err)
+	}
+
+	if _, stderr, err := processed.ExecDir(-1, tmpPath,
+		fmt.Sprintf("initRepoCommit (git init): %s", tmpPath),
+		"git", "init", "--quiet"); err != nil {
+		return fmt.Errorf("git init: %v - %s", err, stderr)
+	}
+	if _, stderr, err := processed.ExecDir(-1, tmpPath,
+		fmt.Sprintf("initRepoCommit (git symbolic-ref): %s", tmpPath),
+		"git", "symbolic-ref", "HEAD", branch); err != nil {
+		return fmt.Errorf("git symbolic-ref: %v - %s", err, stderr)
+	}
+	if err = git.Add(tmpPath, git.AddOptions{All: true}); err != nil {
+		return fmt.Errorf("git add --all: %v", err)
+	}
+	if err = git.CreateCommit(
+		tmpPath,
+		&git.Signature{
+			Name:  opts.Owner,
+			Email: "noreply@" + conf.Server.Domain,
+			When:  time.Now(),
+		},
+		"Initial commit",
+	); err != nil {
+		return fmt.Errorf("commit changes to %q: %v", tmpPath, err)
+	}
+	if err = git.Push(tmpPath, repoPath, branch); err != nil {
+		return fmt.Errorf("git push %s: %v", branch, err)
+	}
+	return nil
+}
+
+// extractTemplate writes the tree of the default branch of the template
+// repository to the directory.
+func extractTemplate(templatePath, dst string) error {
+	// Extract while reading so huge templates are never held in memory as a whole.
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		err := extractTar(r, dst)
+		// Drain what is left so git never blocks on writing.
+		_, _ = io.Copy(io.Discard, r)
+		done <- err
+	}()
+	stderr, err := processed.ExecDirWriter(gitTimeout(conf.Git.Timeout.Clone), templatePath,
+		fmt.Sprintf("extractTemplate (git archive): %s", templatePath),
+		w, "git", "archive", "--format=tar", "HEAD")
+	_ = w.Close()
+	extractErr := <-done
+	if err != nil {
+		return fmt.Errorf("git archive: %v - %s", err, stderr)
+	}
+	return extractErr
+}
+
+// extractTar writes files of the tar archive to the directory.
+func extractTar(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read archive: %v", err)
+		}
+
+		p := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(p, filepath.Clean(dst)+string(filepath.Separator)) {
+			return errors.Errorf("bad path %q in archive", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, os.ModePerm)
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err == nil {
+				err = os.Symlink(hdr.Linkname, p)
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+				break
+			}
+			var f *os.File
+			f, err = os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&os.ModePerm)
+			if err != nil {
+				break
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("extract %q: %v", hdr.Name, err)
+		}
+	}
+}
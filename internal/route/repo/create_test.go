@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-macaron/binding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/context"
+	"git-server/internal/form"
+)
+
+func TestCreateRepository(t *testing.T) {
+	setupTestRoot(t)
+
+	t.Run("auto init", func(t *testing.T) {
+		require.NoError(t, CreateRepository(CreateRepoOptions{
+			Owner:         "alice",
+			Name:          "repo",
+			Description:   "My repository",
+			DefaultBranch: "main",
+			AutoInit:      true,
+			Gitignores:    "Go, macOS",
+			License:       "MIT License",
+		}))
+
+		repoPath := repoPath("alice/repo")
+		assert.Equal(t, "refs/heads/main", strings.TrimSpace(runGit(t, repoPath, "symbolic-ref", "HEAD")))
+		files := runGit(t, repoPath, "ls-tree", "--name-only", "main")
+		assert.Equal(t, ".gitignore\nLICENSE\nREADME.md\n", files)
+		assert.Equal(t, "# repo\n\nMy repository\n", runGit(t, repoPath, "show", "main:README.md"))
+		assert.Contains(t, runGit(t, repoPath, "show", "main:.gitignore"), "# ---> macOS")
+		assert.Contains(t, runGit(t, repoPath, "show", "main:LICENSE"), "Copyright (c) 20")
+		assert.NotContains(t, runGit(t, repoPath, "show", "main:LICENSE"), "[fullname]")
+
+		meta, err := GetRepoMeta("alice/repo")
+		require.NoError(t, err)
+		assert.True(t, meta.IsPrivate, "private by default")
+	})
+
+	t.Run("from template", func(t *testing.T) {
+		require.NoError(t, CreateRepository(CreateRepoOptions{
+			Owner:        "bob",
+			Name:         "repo",
+			TemplateRepo: "alice/repo",
+			AutoInit:     true,
+			IsPublic:     true,
+		}))
+
+		repoPath := repoPath("bob/repo")
+		files := runGit(t, repoPath, "ls-tree", "--name-only", "HEAD")
+		assert.Equal(t, ".gitignore\nLICENSE\nREADME.md\n", files)
+		// The template content is kept instead of generated files.
+		assert.Equal(t, "# repo\n\nMy repository\n", runGit(t, repoPath, "show", "HEAD:README.md"))
+		assert.Equal(t, "1", strings.TrimSpace(runGit(t, repoPath, "rev-list", "--count", "HEAD")))
+
+		meta, err := GetRepoMeta("bob/repo")
+		require.NoError(t, err)
+		assert.False(t, meta.IsPrivate)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		assert.Error(t, CreateRepository(CreateRepoOptions{Owner: "carol", Name: "repo", AutoInit: true, License: "../app.ini"}))
+		assert.Error(t, CreateRepository(CreateRepoOptions{Owner: "carol", Name: "repo", TemplateRepo: "nobody/repo"}))
+		assert.Error(t, CreateRepository(CreateRepoOptions{Owner: "carol", Name: "repo", DefaultBranch: "bad..name"}))
+		assert.False(t, repoExists(repoPath("carol/repo")))
+		assert.Error(t, CreateRepository(CreateRepoOptions{Owner: "alice", Name: "repo"}), "already exists")
+	})
+}
+
+func TestCreatePost_TemplateRepo(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/secret")
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Post("/repo/create", binding.BindIgnErr(form.Repo{}), CreatePost)
+
+	tests := []struct {
+		templateRepo string
+		wantStatus   int
+	}{
+		{"alice/secret", http.StatusUnauthorized},
+		{"alice/../secret", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.templateRepo, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/repo/create",
+				strings.NewReader(`{"Code": "bob", "RepoName": "copy", "TemplateRepo": "`+test.templateRepo+`"}`))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantStatus, resp.Code, resp.Body.String())
+			assert.False(t, repoExists(repoPath("bob/copy")))
+		})
+	}
+}
+
+func TestInitHooks(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	// Repositories created before delegate hooks existed get them on startup.
+	hookPath := filepath.Join(repoPath("alice/repo"), "hooks", "post-receive")
+	require.NoError(t, os.Remove(hookPath))
+	InitHooks()
+	data, err := os.ReadFile(hookPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "hook --config=")
+
+	// Protection of branches survives refreshing hooks.
+	require.NoError(t, updateProtectedBranch("alice/repo", form.ProtectedBranch{BranchName: "master", Protected: true}))
+	InitHooks()
+	branches, err := getProtectedBranches("alice/repo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"master"}, branches)
+}
@@ -59,7 +59,7 @@ func DeleteFilePost(c *context.Context, f form.DeleteRepoFile) {
 		RepoLink:     c.Repo.RepoLink,
 	}); err != nil {
 		log.Error("Failed to delete repo file: %v", err)
-		c.JSON(500, _type.FaildResult(errors.Errorf("%s %v", "repo.editor.fail_to_delete_file")))
+		c.JSON(500, _type.FaildResult(errors.Errorf("%s %v", "repo.editor.fail_to_delete_file", err)))
 		return
 	}
 	c.JSON(200, _type.SuccessResult("成功删除文件"))
@@ -0,0 +1,378 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/form"
+	"git-server/internal/gitutil"
+	processed "git-server/internal/process"
+	"git-server/internal/type"
+)
+
+// emptyTreeID is the ID of the empty tree, which root commits are compared to.
+const emptyTreeID = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// DiffLimits contains limits of a parsed diff, beyond which it is truncated.
+type DiffLimits struct {
+	MaxFiles     int
+	MaxLines     int // Of each file
+	MaxLineChars int
+}
+
+// defaultDiffLimits returns limits of diffs unless requested otherwise.
+func defaultDiffLimits() DiffLimits {
+	return DiffLimits{
+		MaxFiles:     conf.Git.MaxDiffFiles,
+		MaxLines:     conf.Git.MaxDiffLines,
+		MaxLineChars: conf.Git.MaxDiffLineChars,
+	}
+}
+
+// capDiffLimits returns the largest limits of diffs that can be requested,
+// which are never lower than the default ones.
+func capDiffLimits() DiffLimits {
+	max := func(a, b int) int {
+		if a > b {
+			return a
+		}
+		return b
+	}
+	return DiffLimits{
+		MaxFiles:     max(conf.Git.MaxDiffFilesCap, conf.Git.MaxDiffFiles),
+		MaxLines:     max(conf.Git.MaxDiffLinesCap, conf.Git.MaxDiffLines),
+		MaxLineChars: max(conf.Git.MaxDiffLineCharsCap, conf.Git.MaxDiffLineChars),
+	}
+}
+
+// queryDiffLimits returns the limits overridden by positive "maxFiles",
+// "maxLines" and "maxLineChars" in the query, up to caps of the server.
+func queryDiffLimits(c *context.Context, limits DiffLimits) DiffLimits {
+	caps := capDiffLimits()
+	override := func(limit *int, name string, cap int) {
+		if v := c.QueryInt(name); v > 0 {
+			*limit = v
+		}
+		if cap > 0 && (*limit <= 0 || *limit > cap) {
+			*limit = cap
+		}
+	}
+	override(&limits.MaxFiles, "maxFiles", caps.MaxFiles)
+	override(&limits.MaxLines, "maxLines", caps.MaxLines)
+	override(&limits.MaxLineChars, "maxLineChars", caps.MaxLineChars)
+	return limits
+}
+
+// DiffOptions contains options of how diffs are computed.
+type DiffOptions struct {
+	// "all" to ignore all whitespace, "change" to ignore changes in amount of
+	// whitespace, or "eol" to ignore whitespace at line ends. It does not apply
+	// to lists of changed files.
+	IgnoreWhitespace string
+	// Lines of context around changes, negative for the default of git.
+	ContextLines int
+	// Similarity in percent for files to be detected as renamed, zero for the
+	// default of git.
+	RenameThreshold int
+	// Whether inline highlights are computed by words instead of characters.
+	WordDiff bool
+}
+
+// renameArg returns the argument of git for rename detection.
+func (opts DiffOptions) renameArg() string {
+	if opts.RenameThreshold > 0 {
+		return fmt.Sprintf("-M%d%%", opts.RenameThreshold)
+	}
+	return "-M"
+}
+
+// args returns arguments of "git diff" for the options.
+func (opts DiffOptions) args() []string {
+	args := []string{opts.renameArg()}
+	switch opts.IgnoreWhitespace {
+	case "all":
+		args = append(args, "--ignore-all-space")
+	case "change":
+		args = append(args, "--ignore-space-change")
+	case "eol":
+		args = append(args, "--ignore-space-at-eol")
+	}
+	if opts.ContextLines >= 0 {
+		args = append(args, fmt.Sprintf("--unified=%d", opts.ContextLines))
+	}
+	return args
+}
+
+// queryDiffOptions returns options of diffs in the query, which are
+// "ignoreWhitespace", "context", "renameThreshold" and "wordDiff".
+func queryDiffOptions(c *context.Context) (DiffOptions, error) {
+	opts := DiffOptions{
+		IgnoreWhitespace: c.Query("ignoreWhitespace"),
+		ContextLines:     -1,
+		RenameThreshold:  c.QueryInt("renameThreshold"),
+		WordDiff:         c.QueryBool("wordDiff"),
+	}
+	switch opts.IgnoreWhitespace {
+	case "", "all", "change", "eol":
+	default:
+		return opts, errors.Errorf("invalid ignoreWhitespace %q", opts.IgnoreWhitespace)
+	}
+	if c.Query("context") != "" {
+		if opts.ContextLines = c.QueryInt("context"); opts.ContextLines < 0 {
+			return opts, errors.Errorf("invalid context %q", c.Query("context"))
+		}
+	}
+	if opts.RenameThreshold < 0 || opts.RenameThreshold > 100 {
+		return opts, errors.Errorf("invalid renameThreshold %q", c.Query("renameThreshold"))
+	}
+	return opts, nil
+}
+
+// rawFileURL returns the URL of the raw file with the name at the commit of the
+// repository in the path, where each segment of the name is escaped.
+func rawFileURL(repoPath, commitID, name string) string {
+	repoLink, err := filepath.Rel(conf.Repository.Root, strings.TrimSuffix(repoPath, ".git"))
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return conf.Server.ExternalURL + filepath.ToSlash(repoLink) + "/raw/" + commitID + "/" + strings.Join(segments, "/")
+}
+
+// getDiff returns the parsed diff between the commits, of given files if any,
+// with inline highlights unless disabled and metadata of binary files.
+func getDiff(repoPath, from, to string, limits DiffLimits, opts DiffOptions, paths ...string) (*gitutil.Diff, error) {
+	args := append([]string{"diff", "--full-index"}, opts.args()...)
+	args = append(args, from, to, "--")
+	for _, p := range paths {
+		args = append(args, ":(literal)"+p)
+	}
+
+	// Parse while reading so huge diffs are never held in memory as a whole.
+	r, w := io.Pipe()
+	type result struct {
+		diff *gitutil.Diff
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		diff, err := gitutil.ParseDiff(r, limits.MaxFiles, limits.MaxLines, limits.MaxLineChars)
+		// Drain what is left so git never blocks on writing.
+		_, _ = io.Copy(io.Discard, r)
+		done <- result{diff, err}
+	}()
+	stderr, err := processed.ExecDirWriter(gitTimeout(conf.Git.Timeout.Diff), repoPath,
+		fmt.Sprintf("getDiff (git diff): %s", repoPath),
+		w, "git", args...)
+	_ = w.Close()
+	res := <-done
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %v - %s", err, stderr)
+	} else if res.err != nil {
+		return nil, res.err
+	}
+
+	if !conf.Git.DisableDiffHighlight {
+		res.diff.HighlightInline(opts.WordDiff)
+	}
+
+	gitRepo, err := git.Open(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("open repository: %v", err)
+	}
+	if err = res.diff.LoadBinaryDiffs(gitRepo, func(isOld bool, name string) string {
+		if isOld {
+			return rawFileURL(repoPath, from, name)
+		}
+		return rawFileURL(repoPath, to, name)
+	}); err != nil {
+		return nil, fmt.Errorf("load binary diffs: %v", err)
+	}
+	return res.diff, nil
+}
+
+// FileDiff is the diff of a single file.
+type FileDiff struct {
+	FileStat
+	File         *gitutil.DiffFile
+	IsIncomplete bool // Whether hunks are truncated by limits
+}
+
+// GetFileDiff returns the diff of the file between the commits, the name of
+// which is the new one if the file is renamed.
+func GetFileDiff(repoPath, from, to, name string, limits DiffLimits, opts DiffOptions) (*FileDiff, error) {
+	stats, err := getDiffStats(repoPath, opts, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var stat *FileStat
+	for i := range stats.Files {
+		if stats.Files[i].Name == name {
+			stat = &stats.Files[i]
+			break
+		}
+	}
+	if stat == nil {
+		return nil, errors.Errorf("file %q is not changed", name)
+	}
+
+	// Both names are needed for the rename to be detected.
+	paths := []string{stat.Name}
+	if stat.OldName != "" {
+		paths = append(paths, stat.OldName)
+	}
+	limits.MaxFiles = 1
+	diff, err := getDiff(repoPath, from, to, limits, opts, paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	fileDiff := &FileDiff{
+		FileStat:     *stat,
+		IsIncomplete: diff.IsIncomplete(),
+	}
+	if len(diff.Files) > 0 {
+		fileDiff.File = diff.Files[0]
+	}
+	return fileDiff, nil
+}
+
+// diffBase returns the commit to diff the commit against, which is its first
+// parent or the empty tree for root commits.
+func diffBase(commit *git.Commit) (string, error) {
+	if commit.ParentsCount() == 0 {
+		return emptyTreeID, nil
+	}
+	parent, err := commit.ParentID(0)
+	if err != nil {
+		return "", err
+	}
+	return parent.String(), nil
+}
+
+// commitDiffRange returns the range of the commit in the path to diff.
+func commitDiffRange(c *context.Context) (from, to string, err error) {
+	commit, err := c.Repo.GitRepo.CatFileCommit(c.Params(":sha"))
+	if err != nil {
+		return "", "", err
+	}
+	if from, err = diffBase(commit); err != nil {
+		return "", "", err
+	}
+	return from, commit.ID.String(), nil
+}
+
+// renderDiffFiles renders changed files between the commits without hunks.
+func renderDiffFiles(c *context.Context, repoPath, from, to string) {
+	opts, err := queryDiffOptions(c)
+	if err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	stats, err := getDiffStats(repoPath, opts, from, to)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(stats))
+}
+
+// renderFileDiff renders full hunks of the file in "path" between the
+// commits, up to limits in the query which default to caps of the server.
+func renderFileDiff(c *context.Context, repoPath, from, to string) {
+	name := c.Query("path")
+	if name == "" {
+		c.JSON(400, _type.FaildResult(errors.New("path is required")))
+		return
+	}
+	opts, err := queryDiffOptions(c)
+	if err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	fileDiff, err := GetFileDiff(repoPath, from, to, name, queryDiffLimits(c, capDiffLimits()), opts)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(fileDiff))
+}
+
+func CommitDiffFiles(c *context.Context) {
+	from, to, err := commitDiffRange(c)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	renderDiffFiles(c, c.Repo.GitRepo.Path(), from, to)
+}
+
+func CommitFileDiff(c *context.Context) {
+	from, to, err := commitDiffRange(c)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	renderFileDiff(c, c.Repo.GitRepo.Path(), from, to)
+}
+
+func CompareDiffFiles(c *context.Context) {
+	_, head, from, _, err := resolveCompareRange(c.Repo.GitRepo.Path(), c.Query("range"))
+	if err != nil {
+		if IsErrInvalidArgument(err) {
+			c.JSON(400, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	renderDiffFiles(c, c.Repo.GitRepo.Path(), from, head)
+}
+
+func CompareFileDiff(c *context.Context) {
+	_, head, from, _, err := resolveCompareRange(c.Repo.GitRepo.Path(), c.Query("range"))
+	if err != nil {
+		if IsErrInvalidArgument(err) {
+			c.JSON(400, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	renderFileDiff(c, c.Repo.GitRepo.Path(), from, head)
+}
+
+func PullDiffFiles(c *context.Context, f form.PullRequest) {
+	if !checkPullRequestRepos(c, &f) {
+		return
+	}
+	gitRepo, from, to, _, err := pullDiffRange(c, f)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	renderDiffFiles(c, gitRepo.Path(), from, to)
+}
+
+func PullFileDiff(c *context.Context, f form.PullRequest) {
+	if !checkPullRequestRepos(c, &f) {
+		return
+	}
+	gitRepo, from, to, _, err := pullDiffRange(c, f)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	renderFileDiff(c, gitRepo.Path(), from, to)
+}
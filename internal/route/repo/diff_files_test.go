@@ -0,0 +1,280 @@
+package repo
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/gitutil"
+)
+
+func TestGetFileDiff(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	repoPath := repoPath("alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath, ".")
+	long := strings.Repeat("line\n", 20)
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "old.txt"), []byte(long), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "image.bin"), []byte("\x00\x01"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add files")
+	from := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD"))
+
+	runGit(t, workDir, "mv", "old.txt", "new name.txt")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "new name.txt"), []byte(long+"more\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "image.bin"), []byte("\x00\x02"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "big.txt"), []byte(strings.Repeat("big\n", 20)), 0644))
+	require.NoError(t, os.Remove(filepath.Join(workDir, "README.md")))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "change files")
+	to := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD"))
+	runGit(t, workDir, "push", "origin", "master")
+
+	stats, err := getDiffStats(repoPath, DiffOptions{}, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []FileStat{
+		{Name: "README.md", Status: "deleted", Deletions: 1},
+		{Name: "big.txt", Status: "added", Additions: 20},
+		{Name: "image.bin", Status: "modified", IsBinary: true},
+		{Name: "new name.txt", OldName: "old.txt", Status: "renamed", Additions: 1},
+	}, stats.Files)
+	assert.Equal(t, 21, stats.Additions)
+	assert.Equal(t, 1, stats.Deletions)
+
+	fileDiff, err := GetFileDiff(repoPath, from, to, "new name.txt", DiffLimits{}, DiffOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", fileDiff.Status)
+	require.NotNil(t, fileDiff.File)
+	assert.True(t, fileDiff.File.IsRenamed())
+	assert.Equal(t, 1, fileDiff.File.NumAdditions())
+	assert.False(t, fileDiff.IsIncomplete)
+
+	fileDiff, err = GetFileDiff(repoPath, from, to, "big.txt", DiffLimits{MaxLineChars: 3}, DiffOptions{})
+	require.NoError(t, err)
+	assert.True(t, fileDiff.IsIncomplete)
+
+	_, err = GetFileDiff(repoPath, from, to, "unchanged.txt", DiffLimits{}, DiffOptions{})
+	assert.Error(t, err)
+}
+
+func TestQueryDiffLimits(t *testing.T) {
+	before := conf.Git
+	t.Cleanup(func() {
+		conf.Git = before
+	})
+	conf.Git.MaxDiffFiles, conf.Git.MaxDiffLines, conf.Git.MaxDiffLineChars = 10, 100, 100
+	conf.Git.MaxDiffFilesCap, conf.Git.MaxDiffLinesCap, conf.Git.MaxDiffLineCharsCap = 50, 1000, 0
+
+	var got DiffLimits
+	m := macaron.New()
+	m.Use(context.Contexter())
+	m.Get("/", func(c *context.Context) {
+		got = queryDiffLimits(c, defaultDiffLimits())
+	})
+
+	tests := []struct {
+		query string
+		want  DiffLimits
+	}{
+		{"", DiffLimits{MaxFiles: 10, MaxLines: 100, MaxLineChars: 100}},
+		{"maxFiles=20&maxLines=5", DiffLimits{MaxFiles: 20, MaxLines: 5, MaxLineChars: 100}},
+		// Limits cannot be raised beyond caps, which are never lower than defaults.
+		{"maxFiles=100&maxLines=5000&maxLineChars=500", DiffLimits{MaxFiles: 50, MaxLines: 1000, MaxLineChars: 100}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/?"+test.query, nil)
+			require.NoError(t, err)
+			m.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestGetDiff(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	repoPath := repoPath("alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath, ".")
+	lines := []string{"a", "b", "c", "d", "e", "f", "g"}
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "main.go"), []byte(strings.Join(lines, "\n")+"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "old.txt"), []byte("1\n2\n3\n4\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add files")
+	from := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD"))
+
+	lines[0] = "a  "    // Whitespace only
+	lines[3] = "d := 2" // Real change
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "main.go"), []byte(strings.Join(lines, "\n")+"\n"), 0644))
+	runGit(t, workDir, "mv", "old.txt", "new.txt")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "new.txt"), []byte("1\n2\nx\ny\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "change files")
+	to := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD"))
+	runGit(t, workDir, "push", "origin", "master")
+
+	fileDiff := func(opts DiffOptions, name string) *FileDiff {
+		fileDiff, err := GetFileDiff(repoPath, from, to, name, DiffLimits{}, opts)
+		require.NoError(t, err)
+		require.NotNil(t, fileDiff.File)
+		return fileDiff
+	}
+
+	f := fileDiff(DiffOptions{ContextLines: -1}, "main.go")
+	assert.Equal(t, 2, f.File.NumAdditions())
+	f = fileDiff(DiffOptions{ContextLines: -1, IgnoreWhitespace: "all"}, "main.go")
+	assert.Equal(t, 1, f.File.NumAdditions())
+
+	// Without context lines, each change is a section of its own.
+	f = fileDiff(DiffOptions{ContextLines: 0}, "main.go")
+	assert.Len(t, f.File.Sections, 2)
+	f = fileDiff(DiffOptions{ContextLines: -1}, "main.go")
+	assert.Len(t, f.File.Sections, 1)
+
+	// Half of "old.txt" is changed, so it is only renamed with a lower threshold.
+	f = fileDiff(DiffOptions{ContextLines: -1, RenameThreshold: 90}, "new.txt")
+	assert.Equal(t, "added", f.Status)
+	f = fileDiff(DiffOptions{ContextLines: -1, RenameThreshold: 40}, "new.txt")
+	assert.Equal(t, "renamed", f.Status)
+	assert.True(t, f.File.IsRenamed())
+
+	diff, err := getDiff(repoPath, from, to, DiffLimits{}, DiffOptions{ContextLines: 0, WordDiff: true}, "main.go")
+	require.NoError(t, err)
+	require.Len(t, diff.Files, 1)
+	var highlighted []string
+	for _, s := range diff.Files[0].Sections {
+		for _, line := range s.Lines {
+			for _, r := range line.Highlights {
+				highlighted = append(highlighted, line.Content[r.Start:r.End])
+			}
+		}
+	}
+	assert.Equal(t, []string{"  ", " := 2"}, highlighted)
+}
+
+func TestGetDiff_Binary(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	repoPath := repoPath("alice/repo")
+	before := conf.Server.ExternalURL
+	t.Cleanup(func() {
+		conf.Server.ExternalURL = before
+	})
+	conf.Server.ExternalURL = "http://localhost:4000/"
+
+	writePNG := func(t *testing.T, name string, width, height int) {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))))
+		require.NoError(t, os.WriteFile(name, buf.Bytes(), 0644))
+	}
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath, ".")
+	writePNG(t, filepath.Join(workDir, "logo.png"), 3, 2)
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "old.bin"), []byte("\x00\x01"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add files")
+	from := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD"))
+	oldLogo := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD:logo.png"))
+	oldBin := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD:old.bin"))
+
+	writePNG(t, filepath.Join(workDir, "logo.png"), 4, 5)
+	require.NoError(t, os.Remove(filepath.Join(workDir, "old.bin")))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "new.bin"), []byte("\x00\x02\x03"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "change files")
+	to := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD"))
+	newLogo := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD:logo.png"))
+	newBin := strings.TrimSpace(runGit(t, workDir, "rev-parse", "HEAD:new.bin"))
+	runGit(t, workDir, "push", "origin", "master")
+
+	diff, err := getDiff(repoPath, from, to, DiffLimits{}, DiffOptions{ContextLines: -1})
+	require.NoError(t, err)
+	binaries := make(map[string]*gitutil.BinaryDiff)
+	for _, f := range diff.Files {
+		binaries[f.Name] = f.Binary
+	}
+
+	logo := binaries["logo.png"]
+	require.NotNil(t, logo)
+	assert.True(t, logo.IsImage)
+	require.NotNil(t, logo.Old)
+	require.NotNil(t, logo.New)
+	assert.Equal(t, oldLogo, logo.Old.SHA)
+	assert.Equal(t, "http://localhost:4000/alice/repo/raw/"+from+"/logo.png", logo.Old.URL)
+	assert.Equal(t, [2]int{3, 2}, [2]int{logo.Old.Width, logo.Old.Height})
+	assert.Positive(t, logo.Old.Size)
+	assert.Equal(t, newLogo, logo.New.SHA)
+	assert.Equal(t, "http://localhost:4000/alice/repo/raw/"+to+"/logo.png", logo.New.URL)
+	assert.Equal(t, [2]int{4, 5}, [2]int{logo.New.Width, logo.New.Height})
+
+	assert.Equal(t, &gitutil.BinaryDiff{Old: &gitutil.BinaryBlob{SHA: oldBin, Size: 2}}, binaries["old.bin"])
+	assert.Equal(t, &gitutil.BinaryDiff{New: &gitutil.BinaryBlob{SHA: newBin, Size: 3}}, binaries["new.bin"])
+}
+
+func TestRawFileURL(t *testing.T) {
+	setupTestRoot(t)
+	before := conf.Server.ExternalURL
+	t.Cleanup(func() {
+		conf.Server.ExternalURL = before
+	})
+	conf.Server.ExternalURL = "http://localhost:4000/"
+
+	assert.Equal(t, "http://localhost:4000/alice/repo/raw/abc/images/my%20logo%231.png%3Fv=2",
+		rawFileURL(repoPath("alice/repo"), "abc", "images/my logo#1.png?v=2"))
+}
+
+func TestQueryDiffOptions(t *testing.T) {
+	var (
+		got DiffOptions
+		err error
+	)
+	m := macaron.New()
+	m.Use(context.Contexter())
+	m.Get("/", func(c *context.Context) {
+		got, err = queryDiffOptions(c)
+	})
+
+	tests := []struct {
+		query   string
+		want    DiffOptions
+		wantErr bool
+	}{
+		{query: "", want: DiffOptions{ContextLines: -1}},
+		{
+			query: "ignoreWhitespace=change&context=0&renameThreshold=30&wordDiff=true",
+			want:  DiffOptions{IgnoreWhitespace: "change", ContextLines: 0, RenameThreshold: 30, WordDiff: true},
+		},
+		{query: "ignoreWhitespace=some", wantErr: true},
+		{query: "context=-1", wantErr: true},
+		{query: "renameThreshold=101", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			req, rerr := http.NewRequest("GET", "/?"+test.query, nil)
+			require.NoError(t, rerr)
+			m.ServeHTTP(httptest.NewRecorder(), req)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
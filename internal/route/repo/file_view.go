@@ -0,0 +1,353 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/gitutil"
+	"git-server/internal/lazyregexp"
+	"git-server/internal/tool"
+	"git-server/internal/type"
+)
+
+// LFSPointer is the content of a Git LFS pointer file, which stands in for the
+// actual file stored outside of the repository.
+type LFSPointer struct {
+	OID  string // SHA-256 of the actual file
+	Size int64  // Of the actual file
+}
+
+// lfsPointerMaxSize is the max size of a pointer file accepted by Git LFS.
+const lfsPointerMaxSize = 1024
+
+var lfsPointerPattern = lazyregexp.New(`^version https://git-lfs\.github\.com/spec/v1\noid sha256:([0-9a-f]{64})\nsize (\d+)\n`)
+
+// parseLFSPointer returns the pointer if the content is a Git LFS pointer file.
+func parseLFSPointer(data []byte) *LFSPointer {
+	if len(data) > lfsPointerMaxSize {
+		return nil
+	}
+	m := lfsPointerPattern.FindSubmatch(data)
+	if m == nil {
+		return nil
+	}
+	size, err := strconv.ParseInt(string(m[2]), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &LFSPointer{OID: string(m[1]), Size: size}
+}
+
+// shebangInterpreters maps interpreters of shebangs to names of lexers, for
+// those not known to lexers by their own names.
+var shebangInterpreters = map[string]string{
+	"node":   "javascript",
+	"nodejs": "javascript",
+	"deno":   "typescript",
+	"pwsh":   "powershell",
+}
+
+var interpreterVersion = lazyregexp.New(`[0-9.]+$`)
+
+// shebangLexer returns the lexer of the interpreter in the shebang of the
+// content, e.g. "#!/usr/bin/env python3" or "#!/bin/sh".
+func shebangLexer(content []byte) chroma.Lexer {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return nil
+	}
+	line := content[2:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return nil
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		// Skip options of env, e.g. "#!/usr/bin/env -S deno run".
+		interpreter = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				interpreter = path.Base(f)
+				break
+			}
+		}
+	}
+	interpreter = interpreterVersion.ReplaceAllString(interpreter, "")
+	if interpreter == "" {
+		return nil
+	}
+	if name, ok := shebangInterpreters[interpreter]; ok {
+		interpreter = name
+	}
+	return lexers.Get(interpreter)
+}
+
+// detectLexer returns the lexer of the file by its name, or by the shebang of
+// its content if the name is not known.
+func detectLexer(name string, content []byte) chroma.Lexer {
+	if lexer := lexers.Match(name); lexer != nil {
+		return lexer
+	}
+	return shebangLexer(content)
+}
+
+// DetectLanguage returns the language of the file by its name and content, or
+// empty if unknown.
+func DetectLanguage(name string, content []byte) string {
+	if lexer := detectLexer(name, content); lexer != nil {
+		return lexer.Config().Name
+	}
+	return ""
+}
+
+// FileToken is a highlighted token of a line.
+type FileToken struct {
+	// CSS class of the token type used by chroma, e.g. "k" for keywords, empty
+	// for plain text.
+	Class string
+	Text  string
+}
+
+// highlightLines returns tokens of each of the number of lines of the content,
+// or nil if the lexer fails.
+func highlightLines(lexer chroma.Lexer, content string, numLines int) [][]FileToken {
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, content)
+	if err != nil {
+		return nil
+	}
+	tokens := make([][]FileToken, numLines)
+	for i := range tokens {
+		tokens[i] = make([]FileToken, 0)
+	}
+	for i, line := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		if i >= numLines {
+			break
+		}
+		for _, t := range line {
+			text := strings.TrimSuffix(t.Value, "\n")
+			if text == "" {
+				continue
+			}
+			tokens[i] = append(tokens[i], FileToken{
+				Class: chroma.StandardTypes[t.Type],
+				Text:  text,
+			})
+		}
+	}
+	return tokens
+}
+
+// FileViewOptions contains arguments of a file view.
+type FileViewOptions struct {
+	// Lines to return, 1-based and inclusive. Zero StartLine means the first
+	// line and zero EndLine means the last one, up to the max lines of a request.
+	StartLine int
+	EndLine   int
+	// Whether to include highlighted tokens of returned lines.
+	Highlight bool
+}
+
+// FileView is a file with its content and properties.
+type FileView struct {
+	Name     string
+	Path     string
+	Size     int64
+	URL      string // Of the raw file
+	Language string // Empty if unknown
+	Encoding string // Of the file, content is always converted to UTF-8
+	IsBinary bool
+	// Whether the file is larger than the max size of a file view, whose lines
+	// are returned as is without detecting the encoding or highlighting them.
+	IsTooLarge bool
+	IsLFS      bool
+	LFS        *LFSPointer `json:",omitempty"`
+
+	TotalLines int
+	StartLine  int // Of returned lines, zero if none
+	EndLine    int
+	Content    string        // Returned lines of a text file
+	Tokens     [][]FileToken `json:",omitempty"` // Of each returned line if highlighted
+}
+
+// lineRange returns the range of lines to return by the options, where zero
+// end means the last line, capped by the max lines of a request.
+func lineRange(opts FileViewOptions) (start, end int) {
+	start, end = opts.StartLine, opts.EndLine
+	if start == 0 {
+		start = 1
+	}
+	if conf.View.MaxLines > 0 && (end == 0 || end-start+1 > conf.View.MaxLines) {
+		end = start + conf.View.MaxLines - 1
+	}
+	return start, end
+}
+
+// readLines streams lines of the reader and returns those in the range with
+// their newlines, along with the total number of lines, where a trailing
+// newline does not start another line. Lines out of the range are dropped as
+// soon as they are read.
+func readLines(r io.Reader, start, end int) (content string, totalLines int, err error) {
+	br := bufio.NewReader(r)
+	buf := new(strings.Builder)
+	atLineStart := true
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if len(chunk) > 0 {
+			if atLineStart {
+				totalLines++
+			}
+			if totalLines >= start && (end == 0 || totalLines <= end) {
+				buf.Write(chunk)
+			}
+			atLineStart = chunk[len(chunk)-1] == '\n'
+		}
+		if err == io.EOF {
+			return buf.String(), totalLines, nil
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return "", 0, err
+		}
+	}
+}
+
+// GetFileView returns the view of the blob with given path, with lines in the
+// range of options if it is a text file. Only the head of the blob is read for
+// binary files, and lines of files larger than the max size of a file view are
+// streamed without being held in memory.
+func GetFileView(blob *git.Blob, treePath string, opts FileViewOptions) (*FileView, error) {
+	if opts.StartLine < 0 || opts.EndLine < 0 || (opts.EndLine > 0 && opts.EndLine < opts.StartLine) {
+		return nil, ErrInvalidArgument{fmt.Sprintf("invalid line range %d-%d", opts.StartLine, opts.EndLine)}
+	}
+
+	view := &FileView{
+		Name: path.Base(treePath),
+		Path: treePath,
+		Size: blob.Size(),
+	}
+	r := gitutil.BlobReader(blob)
+	defer r.Close()
+
+	// The head is enough to sniff the content and to hold a whole LFS pointer.
+	head := make([]byte, lfsPointerMaxSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if view.Size <= lfsPointerMaxSize {
+		if view.LFS = parseLFSPointer(head); view.LFS != nil {
+			view.IsLFS = true
+			view.Language = DetectLanguage(view.Name, nil)
+			return view, nil
+		}
+	}
+	if !tool.IsTextFile(head) {
+		view.IsBinary = true
+		return view, nil
+	}
+	start, end := lineRange(opts)
+	if conf.View.MaxFileSize > 0 && view.Size > conf.View.MaxFileSize*1024 {
+		view.IsTooLarge = true
+		view.Language = DetectLanguage(view.Name, head)
+		content, totalLines, err := readLines(io.MultiReader(bytes.NewReader(head), r), start, end)
+		if err != nil {
+			return nil, err
+		}
+		view.TotalLines = totalLines
+		if end == 0 || end > totalLines {
+			end = totalLines
+		}
+		if start <= end {
+			view.StartLine, view.EndLine, view.Content = start, end, content
+		}
+		return view, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, view.Size))
+	buf.Write(head)
+	if _, err = buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	view.Encoding = "UTF-8"
+	if encoding, err := tool.DetectEncoding(data); err == nil && encoding != "UTF-8" {
+		if e, _ := charset.Lookup(encoding); e != nil {
+			if converted, _, err := transform.Bytes(e.NewDecoder(), data); err == nil {
+				view.Encoding, data = encoding, converted
+			}
+		}
+	}
+	content := string(data)
+	lexer := detectLexer(view.Name, data)
+	if lexer != nil {
+		view.Language = lexer.Config().Name
+	}
+
+	lines, totalLines, err := readLines(strings.NewReader(content), start, end)
+	if err != nil {
+		return nil, err
+	}
+	view.TotalLines = totalLines
+	if end == 0 || end > view.TotalLines {
+		end = view.TotalLines
+	}
+	if start > end {
+		return view, nil
+	}
+	view.StartLine, view.EndLine, view.Content = start, end, lines
+
+	if opts.Highlight && lexer != nil &&
+		(conf.View.MaxHighlightSize <= 0 || int64(len(data)) <= conf.View.MaxHighlightSize*1024) {
+		// Tokens depend on lines above, so the whole file is always highlighted.
+		if tokens := highlightLines(lexer, content, view.TotalLines); tokens != nil {
+			view.Tokens = tokens[start-1 : end]
+		}
+	}
+	return view, nil
+}
+
+// ViewFile returns the file at the reference with lines in "start" to "end",
+// and highlighted tokens of them if "highlight" is true.
+func ViewFile(c *context.Context) {
+	if c.Repo.TreePath == "" {
+		c.JSON(400, _type.FaildResult(errors.New("path is required")))
+		return
+	}
+	blob, err := c.Repo.Commit.Blob(c.Repo.TreePath)
+	if err != nil {
+		c.JSON(404, _type.FaildResult(err))
+		return
+	}
+
+	view, err := GetFileView(blob, c.Repo.TreePath, FileViewOptions{
+		StartLine: c.QueryInt("start"),
+		EndLine:   c.QueryInt("end"),
+		Highlight: c.QueryBool("highlight"),
+	})
+	if err != nil {
+		if IsErrInvalidArgument(err) {
+			c.JSON(400, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	view.URL = rawFileURL(c.Repo.GitRepo.Path(), c.Repo.CommitID, c.Repo.TreePath)
+	c.JSON(200, _type.SuccessResult(view))
+}
@@ -0,0 +1,219 @@
+package repo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-macaron/binding"
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/form"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "main.go", want: "Go"},
+		{name: "Makefile", want: "Makefile"},
+		{name: "build", content: "#!/bin/sh\necho\n", want: "Bash"},
+		{name: "run", content: "#!/usr/bin/env python3\nprint()\n", want: "Python"},
+		{name: "serve", content: "#!/usr/bin/env node\n", want: "JavaScript"},
+		{name: "notes", content: "plain text\n", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, DetectLanguage(test.name, []byte(test.content)))
+		})
+	}
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	pointer := parseLFSPointer([]byte("version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize 12345\n"))
+	require.NotNil(t, pointer)
+	assert.Equal(t, LFSPointer{OID: oid, Size: 12345}, *pointer)
+
+	assert.Nil(t, parseLFSPointer([]byte("version 1\nsize 1\n")))
+}
+
+func TestReadLines(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	input := "a\n" + long + "\nb\n" + long
+	tests := []struct {
+		start, end int
+		want       string
+	}{
+		{1, 0, input},
+		{2, 2, long + "\n"},
+		{3, 4, "b\n" + long},
+		{5, 0, ""},
+	}
+	for _, test := range tests {
+		content, totalLines, err := readLines(strings.NewReader(input), test.start, test.end)
+		require.NoError(t, err)
+		assert.Equal(t, 4, totalLines)
+		assert.Equal(t, test.want, content)
+	}
+}
+
+func TestGetFileView(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	before := conf.View
+	t.Cleanup(func() {
+		conf.View = before
+	})
+	conf.View.MaxLines, conf.View.MaxHighlightSize, conf.View.MaxFileSize = 3, 0, 1
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "main.go"),
+		[]byte("package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "latin1.txt"), []byte("caf\xe9 cr\xe8me br\xfbl\xe9e\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "image.bin"), []byte("\x00\x01\x02"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "large.go"), []byte("package large\n"+strings.Repeat("// large\n", 1<<10)), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "large.bin"), append([]byte{0}, make([]byte, 4<<20)...), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add files")
+	runGit(t, workDir, "push", "origin", "master")
+
+	gitRepo, err := git.Open(repoPath("alice/repo"))
+	require.NoError(t, err)
+	commit, err := gitRepo.BranchCommit("master")
+	require.NoError(t, err)
+	view := func(treePath string, opts FileViewOptions) *FileView {
+		blob, err := commit.Blob(treePath)
+		require.NoError(t, err)
+		view, err := GetFileView(blob, treePath, opts)
+		require.NoError(t, err)
+		return view
+	}
+
+	// Lines are capped by the max lines of a request.
+	v := view("main.go", FileViewOptions{})
+	assert.Equal(t, "Go", v.Language)
+	assert.Equal(t, "UTF-8", v.Encoding)
+	assert.Equal(t, 6, v.TotalLines)
+	assert.Equal(t, [2]int{1, 3}, [2]int{v.StartLine, v.EndLine})
+	assert.Equal(t, "package main\n\nfunc main() {\n", v.Content)
+	assert.Nil(t, v.Tokens)
+
+	v = view("main.go", FileViewOptions{StartLine: 4, EndLine: 10, Highlight: true})
+	assert.Equal(t, [2]int{4, 6}, [2]int{v.StartLine, v.EndLine})
+	assert.Equal(t, "\tprintln(\"hi\")\n}\n\n", v.Content)
+	require.Len(t, v.Tokens, 3)
+	assert.Contains(t, v.Tokens[0], FileToken{Class: "nb", Text: "println"})
+	assert.Equal(t, []FileToken{{Class: "p", Text: "}"}}, v.Tokens[1])
+	assert.Empty(t, v.Tokens[2])
+
+	v = view("main.go", FileViewOptions{StartLine: 9})
+	assert.Zero(t, v.StartLine)
+	assert.Empty(t, v.Content)
+
+	v = view("latin1.txt", FileViewOptions{})
+	assert.NotEqual(t, "UTF-8", v.Encoding)
+	assert.Equal(t, "café crème brûlée\n", v.Content)
+
+	v = view("image.bin", FileViewOptions{})
+	assert.True(t, v.IsBinary)
+	assert.Equal(t, int64(3), v.Size)
+	assert.Empty(t, v.Content)
+
+	// Lines of large files are streamed without highlighting.
+	v = view("large.go", FileViewOptions{Highlight: true})
+	assert.True(t, v.IsTooLarge)
+	assert.Equal(t, "Go", v.Language)
+	assert.Equal(t, 1<<10+1, v.TotalLines)
+	assert.Equal(t, [2]int{1, 3}, [2]int{v.StartLine, v.EndLine})
+	assert.Equal(t, "package large\n// large\n// large\n", v.Content)
+	assert.Nil(t, v.Tokens)
+
+	v = view("large.go", FileViewOptions{StartLine: 1 << 10, EndLine: 1 << 11})
+	assert.Equal(t, [2]int{1 << 10, 1<<10 + 1}, [2]int{v.StartLine, v.EndLine})
+	assert.Equal(t, "// large\n// large\n", v.Content)
+
+	v = view("large.go", FileViewOptions{StartLine: 1 << 11})
+	assert.Zero(t, v.StartLine)
+	assert.Empty(t, v.Content)
+
+	v = view("large.bin", FileViewOptions{})
+	assert.True(t, v.IsBinary)
+	assert.False(t, v.IsTooLarge)
+	assert.Equal(t, int64(4<<20+1), v.Size)
+
+	blob, err := commit.Blob("main.go")
+	require.NoError(t, err)
+	_, err = GetFileView(blob, "main.go", FileViewOptions{StartLine: 3, EndLine: 2})
+	assert.True(t, IsErrInvalidArgument(err))
+}
+
+func TestViewFile(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	before := conf.Server.ExternalURL
+	t.Cleanup(func() {
+		conf.Server.ExternalURL = before
+	})
+	conf.Server.ExternalURL = "http://localhost:4000/"
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, "docs"), os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "docs", "a #1.md"), []byte("# a\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add docs")
+	runGit(t, workDir, "push", "origin", "master")
+
+	gitRepo, err := git.Open(repoPath("alice/repo"))
+	require.NoError(t, err)
+	commit, err := gitRepo.BranchCommit("master")
+	require.NoError(t, err)
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Get("/file/*", func(c *context.Context) {
+		c.Repo.GitRepo = gitRepo
+		c.Repo.Commit = commit
+		c.Repo.CommitID = commit.ID.String()
+		c.Repo.TreePath = c.Params("*")
+	}, ViewFile)
+	m.Post("/markdown/*", func(c *context.Context) {
+		c.Repo.GitRepo = gitRepo
+		c.Repo.CommitID = commit.ID.String()
+		c.Repo.TreePath = c.Params("*")
+	}, binding.BindIgnErr(form.Markdown{}), RenderMarkdown)
+
+	req, err := http.NewRequest("GET", "/file/docs/a%20%231.md", nil)
+	require.NoError(t, err)
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var result struct {
+		Data FileView
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Equal(t, "http://localhost:4000/alice/repo/raw/"+commit.ID.String()+"/docs/a%20%231.md", result.Data.URL)
+
+	// Relative links of markdown are resolved against raw files at the commit.
+	req, err = http.NewRequest("POST", "/markdown/docs/a%20%231.md", strings.NewReader(`{"Text": "![logo](b%20%232.png)"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "http://localhost:4000/alice/repo/raw/"+commit.ID.String()+"/docs/b%20%232.png")
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/form"
+	processed "git-server/internal/process"
+	"git-server/internal/repoutil"
+	"git-server/internal/type"
+)
+
+// gitTimeout converts a timeout in seconds from the configuration to the duration
+// accepted by the process package, non-positive values fall back to the default.
+func gitTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return -1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func ForkPost(c *context.Context, f form.ForkRepo) {
+	repoName := f.RepoName
+	if repoName == "" {
+		repoName = strings.TrimSuffix(c.Params(":reponame"), ".git")
+	}
+	if err := validateRepoName(f.Code, repoName); err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	// Forks are created under an owner of the user, who only needs read access
+	// to the base repository.
+	if !authorizeBasic(c.Context, f.Code) {
+		return
+	}
+
+	forkLink := repoutil.FullRepoName(f.Code, repoName)
+	if err := ForkRepository(c.Repo.RepoLink, forkLink); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(repoutil.CloneLink{
+		HTTPS: repoutil.HTTPSCloneURL(f.Code, repoName),
+	}))
+}
+
+// ForkRepository creates a bare clone of the base repository as a new repository,
+// objects of the base repository are borrowed through alternates to save disk space.
+func ForkRepository(baseLink, forkLink string) (err error) {
+	basePath := repoPath(baseLink)
+	forkPath := repoPath(forkLink)
+	if repoExists(forkPath) {
+		return errors.Errorf("repository %q already exists", forkLink)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(forkPath), os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(forkPath)
+		}
+	}()
+
+	var stderr string
+	if _, stderr, err = processed.ExecTimeout(gitTimeout(conf.Git.Timeout.Clone),
+		fmt.Sprintf("ForkRepository (git clone): %s -> %s", baseLink, forkLink),
+		"git", "clone", "--bare", "--reference", basePath, basePath, forkPath); err != nil {
+		return fmt.Errorf("git clone: %v - %s", err, stderr)
+	}
+
+	if err = createDelegateHooks(forkPath); err != nil {
+		return fmt.Errorf("createDelegateHooks: %v", err)
+	}
+
+	if err = UpdateRepoMeta(forkLink, func(meta *RepoMeta) {
+		meta.ForkFrom = baseLink
+	}); err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	}
+	return nil
+}
+
+func ListForks(c *context.Context) {
+	forks, err := GetForks(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(forks))
+}
+
+// GetForks returns full names of repositories that are directly forked from
+// the repository by given repository link.
+func GetForks(repoLink string) ([]string, error) {
+	repos, err := getAllRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	forks := make([]string, 0)
+	for _, r := range repos {
+		link := strings.TrimSuffix(r, ".git")
+		meta, err := GetRepoMeta(link)
+		if err != nil {
+			return nil, fmt.Errorf("get repository meta %q: %v", link, err)
+		}
+		if meta.ForkFrom == repoLink {
+			forks = append(forks, link)
+		}
+	}
+	return forks, nil
+}
+
+// findForkByOwner returns the repository link of given owner that is in the same
+// fork relation with the base repository, i.e. either the upstream of the base
+// repository or a fork of it.
+func findForkByOwner(baseLink, owner string) (string, error) {
+	meta, err := GetRepoMeta(baseLink)
+	if err != nil {
+		return "", fmt.Errorf("get repository meta: %v", err)
+	}
+	if meta.IsFork() && strings.HasPrefix(meta.ForkFrom, owner+"/") {
+		return meta.ForkFrom, nil
+	}
+
+	repos, err := GetRepos(owner)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range repos {
+		link := strings.TrimSuffix(r, ".git")
+		forkMeta, err := GetRepoMeta(link)
+		if err != nil {
+			return "", fmt.Errorf("get repository meta %q: %v", link, err)
+		}
+		if forkMeta.ForkFrom == baseLink {
+			return link, nil
+		}
+	}
+	return "", errors.Errorf("no fork of %q is owned by %q", baseLink, owner)
+}
@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-macaron/binding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/form"
+)
+
+// setupTestRoot points the repository root to a temporary directory for the test,
+// and makes delegate hooks no-op.
+func setupTestRoot(t *testing.T) {
+	oldRepository := conf.Repository
+	conf.Repository.Root = filepath.Join(t.TempDir(), "repositories")
+	conf.Repository.LocalPath = filepath.Join(t.TempDir(), "local")
+	conf.Repository.ScriptType = "sh"
+	conf.Repository.BashPath = "true"
+	t.Cleanup(func() {
+		conf.Repository = oldRepository
+	})
+}
+
+// runGit runs a Git command in given directory and fails the test on error.
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+		"GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "git %v: %s", args, out)
+	return string(out)
+}
+
+// newTestRepo creates a bare repository by given repository link with an initial
+// commit on the "master" branch.
+func newTestRepo(t *testing.T, repoLink string) {
+	require.NoError(t, initRepo(repoLink))
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "init", "-b", "master")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "README.md"), []byte("# test\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "initial commit")
+	runGit(t, workDir, "push", repoPath(repoLink), "master")
+}
+
+func TestForkRepository(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/base")
+
+	require.NoError(t, ForkRepository("alice/base", "bob/base"))
+	assert.Error(t, ForkRepository("alice/base", "bob/base"), "fork twice")
+
+	meta, err := GetRepoMeta("bob/base")
+	require.NoError(t, err)
+	assert.Equal(t, "alice/base", meta.ForkFrom)
+	assert.FileExists(t, filepath.Join(repoPath("bob/base"), "objects", "info", "alternates"))
+	assert.Contains(t, runGit(t, repoPath("bob/base"), "branch"), "master")
+
+	forks, err := GetForks("alice/base")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob/base"}, forks)
+
+	link, err := findForkByOwner("alice/base", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "bob/base", link)
+
+	link, err = findForkByOwner("bob/base", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice/base", link)
+
+	_, err = findForkByOwner("alice/base", "carol")
+	assert.Error(t, err)
+}
+
+func TestForkPost(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/base")
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Post("/:username/:reponame/fork", func(c *context.Context) {
+		c.Repo.RepoLink = "alice/base"
+	}, binding.BindIgnErr(form.ForkRepo{}), ForkPost)
+	m.Post("/migrate", binding.BindIgnErr(form.MigrateRepo{}), MigratePost)
+
+	tests := []struct {
+		name       string
+		url        string
+		body       string
+		wantStatus int
+	}{
+		{"no owner", "/alice/base/fork", `{}`, http.StatusBadRequest},
+		{"owner above root", "/alice/base/fork", `{"Code": ".."}`, http.StatusBadRequest},
+		{"name above root", "/alice/base/fork", `{"Code": "bob", "RepoName": "../../x"}`, http.StatusBadRequest},
+		{"anonymous", "/alice/base/fork", `{"Code": "bob"}`, http.StatusUnauthorized},
+		{"migrate owner above root", "/migrate", `{"Code": "..", "RepoName": "x"}`, http.StatusBadRequest},
+		{"migrate anonymous", "/migrate", `{"Code": "bob", "RepoName": "x"}`, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", test.url, strings.NewReader(test.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantStatus, resp.Code, resp.Body.String())
+		})
+	}
+	assert.NoDirExists(t, filepath.Join(filepath.Dir(conf.Repository.Root), "x.git"))
+	assert.False(t, repoExists(repoPath("bob/base")))
+}
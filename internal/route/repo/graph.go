@@ -0,0 +1,232 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogs/git-module"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	processed "git-server/internal/process"
+	"git-server/internal/type"
+)
+
+// GraphCommit is a commit in the commit graph with its position to be drawn.
+type GraphCommit struct {
+	ID        string
+	Parents   []string
+	Author    *git.Signature
+	Committer *git.Signature
+	Message   string
+	// Full names of references pointing at the commit, e.g. "refs/heads/master".
+	Refs []string
+	// The column of the commit, starting from zero.
+	Column int
+	// The columns where lines to each parent continue below the commit.
+	ParentColumns []int
+}
+
+// GraphOptions contains arguments of a commit graph.
+type GraphOptions struct {
+	// Revisions to start from, e.g. "master" or "v1.0..master".
+	Revs []string
+	// Whether to start from all branches and tags instead of Revs.
+	All bool
+
+	Page     int // 1-based
+	PageSize int
+}
+
+const (
+	// maxGraphPageSize is the max number of commits in a page of the commit graph.
+	maxGraphPageSize = 100
+	// maxGraphCommits is the max number of commits up to the end of a page, all
+	// of which are computed to assign columns.
+	maxGraphCommits = 10000
+)
+
+// GetCommitGraph returns a page of commits reachable from the revisions in
+// topological order, with columns assigned like "git log --graph". The page
+// size is capped by maxGraphPageSize, and pages beyond maxGraphCommits are
+// rejected.
+func GetCommitGraph(repoPath string, opts GraphOptions) ([]*GraphCommit, error) {
+	page, pageSize := opts.Page, opts.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 5
+	} else if pageSize > maxGraphPageSize {
+		pageSize = maxGraphPageSize
+	}
+	if page > maxGraphCommits/pageSize {
+		return nil, ErrInvalidArgument{fmt.Sprintf("page %d is beyond the first %d commits", page, maxGraphCommits)}
+	}
+	revs := opts.Revs
+	if opts.All {
+		revs = []string{"--branches", "--tags"}
+	} else {
+		for _, rev := range revs {
+			if rev == "" || strings.HasPrefix(rev, "-") {
+				return nil, ErrInvalidArgument{fmt.Sprintf("invalid revision %q", rev)}
+			}
+		}
+		// Check revisions beforehand to tell nonexistent ones from failures of Git.
+		args := append([]string{"rev-parse"}, revs...)
+		args = append(args, "--")
+		if _, stderr, err := processed.ExecDir(-1, repoPath,
+			fmt.Sprintf("GetCommitGraph (git rev-parse): %s", repoPath),
+			"git", args...); err != nil {
+			return nil, ErrInvalidArgument{fmt.Sprintf("invalid revisions: %s", strings.TrimSpace(stderr))}
+		}
+	}
+
+	// Columns depend on all commits above, so the graph is always computed from the top.
+	args := []string{"log", "-z", "--topo-order", "--decorate=full",
+		"--max-count=" + strconv.Itoa(page*pageSize),
+		"--format=%H%x1f%P%x1f%D%x1f%an%x1f%ae%x1f%at%x1f%cn%x1f%ce%x1f%ct%x1f%B"}
+	args = append(args, revs...)
+	args = append(args, "--")
+	stdout, stderr, err := processed.ExecDir(gitTimeout(conf.Git.Timeout.Diff), repoPath,
+		fmt.Sprintf("GetCommitGraph (git log): %s", repoPath),
+		"git", args...)
+	if err != nil {
+		return nil, fmt.Errorf("git log: %v - %s", err, stderr)
+	}
+
+	var (
+		commits []*GraphCommit
+		lanes   []string // Commit IDs expected in each column, empty if free
+	)
+	for _, record := range strings.Split(stdout, "\x00") {
+		fields := strings.SplitN(record, "\x1f", 10)
+		if len(fields) != 10 {
+			continue
+		}
+		c := &GraphCommit{
+			ID:        fields[0],
+			Parents:   strings.Fields(fields[1]),
+			Refs:      parseDecorations(fields[2]),
+			Author:    parseSignature(fields[3], fields[4], fields[5]),
+			Committer: parseSignature(fields[6], fields[7], fields[8]),
+			Message:   fields[9],
+		}
+		lanes = placeCommit(lanes, c)
+		commits = append(commits, c)
+	}
+
+	start := (page - 1) * pageSize
+	if start >= len(commits) {
+		return []*GraphCommit{}, nil
+	}
+	return commits[start:], nil
+}
+
+// placeCommit assigns the column of the commit and its parents based on lanes
+// of commits above, and returns the lanes for commits below.
+func placeCommit(lanes []string, c *GraphCommit) []string {
+	c.Column = -1
+	for i, id := range lanes {
+		if id != c.ID {
+			continue
+		}
+		if c.Column < 0 {
+			c.Column = i
+		} else {
+			// Other lines converge to the commit.
+			lanes[i] = ""
+		}
+	}
+	if c.Column < 0 {
+		c.Column, lanes = freeLane(lanes)
+	}
+
+	lanes[c.Column] = ""
+	c.ParentColumns = make([]int, len(c.Parents))
+	for i, parent := range c.Parents {
+		col := -1
+		for j, id := range lanes {
+			if id == parent {
+				col = j
+				break
+			}
+		}
+		if col < 0 {
+			if i == 0 {
+				// The first parent continues in the same column.
+				col = c.Column
+			} else {
+				col, lanes = freeLane(lanes)
+			}
+			lanes[col] = parent
+		}
+		c.ParentColumns[i] = col
+	}
+
+	// Drop free lanes at the end so the graph does not grow wider than needed.
+	for len(lanes) > 0 && lanes[len(lanes)-1] == "" {
+		lanes = lanes[:len(lanes)-1]
+	}
+	return lanes
+}
+
+// freeLane returns the first free lane, which is appended if none is free.
+func freeLane(lanes []string) (int, []string) {
+	for i, id := range lanes {
+		if id == "" {
+			return i, lanes
+		}
+	}
+	return len(lanes), append(lanes, "")
+}
+
+// parseDecorations parses full reference names from "%D" of "git log --decorate=full".
+func parseDecorations(s string) []string {
+	refs := make([]string, 0)
+	for _, ref := range strings.Split(s, ", ") {
+		ref = strings.TrimPrefix(ref, "HEAD -> ")
+		ref = strings.TrimPrefix(ref, "tag: ")
+		if strings.HasPrefix(ref, "refs/") {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func parseSignature(name, email, unix string) *git.Signature {
+	sec, _ := strconv.ParseInt(unix, 10, 64)
+	return &git.Signature{
+		Name:  name,
+		Email: email,
+		When:  time.Unix(sec, 0),
+	}
+}
+
+// CommitGraph returns a page of the commit graph of the reference, or of the
+// revisions in "revs" separated by commas (e.g. "v1.0..master,develop"), or of
+// all branches and tags if "all" is true.
+func CommitGraph(c *context.Context) {
+	opts := GraphOptions{
+		Revs:     []string{c.Repo.CommitID},
+		All:      c.QueryBool("all"),
+		Page:     c.QueryInt("page"),
+		PageSize: c.QueryInt("pageSize"),
+	}
+	if revs := c.Query("revs"); revs != "" {
+		opts.Revs = strings.Split(revs, ",")
+	}
+
+	commits, err := GetCommitGraph(c.Repo.GitRepo.Path(), opts)
+	if err != nil {
+		if IsErrInvalidArgument(err) {
+			c.JSON(400, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(commits))
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCommitGraph(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	// A history of:
+	//
+	// *   merge feature (master)
+	// |\
+	// | * add feature (feature)
+	// * | add main
+	// |/
+	// * initial commit (v1.0)
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	runGit(t, workDir, "tag", "v1.0")
+	runGit(t, workDir, "checkout", "-b", "feature")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "feature.go"), []byte("package main\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add feature")
+	runGit(t, workDir, "checkout", "master")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "main.go"), []byte("package main\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add main")
+	runGit(t, workDir, "merge", "--no-ff", "-m", "merge feature", "feature")
+	runGit(t, workDir, "push", "origin", "master", "feature", "v1.0")
+
+	commits, err := GetCommitGraph(repoPath("alice/repo"), GraphOptions{All: true, PageSize: 10})
+	require.NoError(t, err)
+	require.Len(t, commits, 4)
+
+	ids := make(map[string]string) // By summary
+	for _, c := range commits {
+		ids[strings.SplitN(c.Message, "\n", 2)[0]] = c.ID
+	}
+
+	merge := commits[0]
+	assert.Equal(t, "merge feature\n", merge.Message)
+	assert.Equal(t, []string{"refs/heads/master"}, merge.Refs)
+	assert.Equal(t, []string{ids["add main"], ids["add feature"]}, merge.Parents)
+	assert.Equal(t, 0, merge.Column)
+	assert.Equal(t, []int{0, 1}, merge.ParentColumns)
+	assert.Equal(t, "tester", merge.Author.Name)
+
+	// Lines from each commit lead to the columns of its parents.
+	columns := make(map[string]int)
+	for _, c := range commits {
+		columns[c.ID] = c.Column
+	}
+	for _, c := range commits {
+		for i, parent := range c.Parents {
+			assert.Equal(t, columns[parent], c.ParentColumns[i], "parent %d of %q", i, c.Message)
+		}
+	}
+	assert.NotEqual(t, columns[ids["add main"]], columns[ids["add feature"]])
+	assert.Equal(t, ids["initial commit"], commits[3].ID)
+	assert.Equal(t, []string{"refs/tags/v1.0"}, commits[3].Refs)
+
+	// Columns of a page are the same as those of the whole graph.
+	page, err := GetCommitGraph(repoPath("alice/repo"), GraphOptions{All: true, Page: 2, PageSize: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, commits[2:], page)
+
+	// Ranges of revisions are supported.
+	commits, err = GetCommitGraph(repoPath("alice/repo"), GraphOptions{Revs: []string{"v1.0..feature"}})
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	assert.Equal(t, ids["add feature"], commits[0].ID)
+	assert.Equal(t, []string{"refs/heads/feature"}, commits[0].Refs)
+
+	_, err = GetCommitGraph(repoPath("alice/repo"), GraphOptions{Revs: []string{"--output=x"}})
+	assert.True(t, IsErrInvalidArgument(err))
+	_, err = GetCommitGraph(repoPath("alice/repo"), GraphOptions{Revs: []string{"v1.0..missing"}})
+	assert.True(t, IsErrInvalidArgument(err))
+
+	// Page sizes are capped and deep pages are rejected.
+	commits, err = GetCommitGraph(repoPath("alice/repo"), GraphOptions{All: true, PageSize: 1 << 30})
+	require.NoError(t, err)
+	assert.Len(t, commits, 4)
+	_, err = GetCommitGraph(repoPath("alice/repo"), GraphOptions{All: true, Page: maxGraphCommits/maxGraphPageSize + 1, PageSize: maxGraphPageSize})
+	assert.True(t, IsErrInvalidArgument(err))
+	_, err = GetCommitGraph(repoPath("alice/repo"), GraphOptions{All: true, Page: 1 << 40, PageSize: 1 << 30})
+	assert.True(t, IsErrInvalidArgument(err))
+}
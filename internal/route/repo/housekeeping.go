@@ -0,0 +1,217 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	processed "git-server/internal/process"
+	"git-server/internal/sync"
+	"git-server/internal/type"
+)
+
+// Housekeeping contains status of maintenance tasks of a repository.
+type Housekeeping struct {
+	LastPush  time.Time
+	LastRun   time.Time
+	LastError string
+	LastFsck  time.Time
+	// Problems found by the last fsck, empty if the repository is healthy.
+	FsckError string
+}
+
+// HousekeepingQueue holds repository links to be maintained.
+var HousekeepingQueue = sync.NewUniqueQueue(1000)
+
+// fsckTable marks repositories in the queue that should also be checked by fsck.
+var fsckTable = sync.NewStatusTable()
+
+const taskHousekeepingUpdate = "housekeeping_update"
+
+// recordPush records the time of the latest push to the repository, so it is
+// maintained by the next scheduled housekeeping.
+func recordPush(repoLink string) error {
+	return UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		if meta.Housekeeping == nil {
+			meta.Housekeeping = new(Housekeeping)
+		}
+		meta.Housekeeping.LastPush = time.Now()
+	})
+}
+
+// Housekeep runs git gc, repack and commit-graph on the repository, and fsck if
+// requested, then records the results.
+func Housekeep(repoLink string, fsck bool) error {
+	repoPath := repoPath(repoLink)
+	if !repoExists(repoPath) {
+		return errors.Errorf("repository %q does not exist", repoLink)
+	}
+
+	gcArgs := []string{"gc", "--auto", "--quiet"}
+	forks, err := GetForks(repoLink)
+	if err != nil {
+		return fmt.Errorf("get forks: %v", err)
+	} else if len(forks) > 0 {
+		// Forks borrow objects through alternates, which must be kept even if
+		// they are no longer reachable from this repository.
+		gcArgs = append(gcArgs, "--prune=never")
+	}
+
+	timeout := gitTimeout(conf.Git.Timeout.GC)
+	var runErr error
+	for _, args := range [][]string{
+		gcArgs,
+		{"repack", "-d", "-l", "--quiet"},
+		{"commit-graph", "write", "--reachable"},
+	} {
+		if _, stderr, err := processed.ExecDir(timeout, repoPath,
+			fmt.Sprintf("Housekeep (git %s): %s", args[0], repoLink),
+			"git", args...); err != nil {
+			runErr = fmt.Errorf("git %s: %v - %s", args[0], err, stderr)
+			break
+		}
+	}
+	InvalidateRepoSize(repoLink)
+
+	var fsckErr error
+	if fsck {
+		if stdout, stderr, err := processed.ExecDir(timeout, repoPath,
+			fmt.Sprintf("Housekeep (git fsck): %s", repoLink),
+			"git", "fsck", "--no-progress", "--no-dangling"); err != nil {
+			fsckErr = fmt.Errorf("git fsck: %v - %s", err, strings.TrimSpace(stdout+"\n"+stderr))
+		}
+	}
+
+	now := time.Now()
+	if err = UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		if meta.Housekeeping == nil {
+			meta.Housekeeping = new(Housekeeping)
+		}
+		h := meta.Housekeeping
+		h.LastRun = now
+		h.LastError = ""
+		if runErr != nil {
+			h.LastError = runErr.Error()
+		}
+		if fsck {
+			h.LastFsck = now
+			h.FsckError = ""
+			if fsckErr != nil {
+				h.FsckError = fsckErr.Error()
+			}
+		}
+	}); err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	return fsckErr
+}
+
+// AddHousekeeping queues the repository to be maintained, and checked by fsck
+// if requested.
+func AddHousekeeping(repoLink string, fsck bool) {
+	HousekeepingQueue.AddFunc(repoLink, func() {
+		if fsck {
+			fsckTable.Start(repoLink)
+		}
+	})
+}
+
+// HousekeepingUpdate checks and queues repositories that have been pushed since
+// last maintained, or are due to be checked by fsck.
+func HousekeepingUpdate() {
+	if taskStatusTable.IsRunning(taskHousekeepingUpdate) {
+		return
+	}
+	taskStatusTable.Start(taskHousekeepingUpdate)
+	defer taskStatusTable.Stop(taskHousekeepingUpdate)
+
+	log.Trace("Doing: HousekeepingUpdate")
+
+	repos, err := getAllRepos()
+	if err != nil {
+		log.Error("HousekeepingUpdate: list repositories: %v", err)
+		return
+	}
+
+	fsckInterval := time.Duration(conf.Housekeeping.FsckInterval) * time.Hour
+	now := time.Now()
+	for _, r := range repos {
+		repoLink := strings.TrimSuffix(r, ".git")
+		meta, err := GetRepoMeta(repoLink)
+		if err != nil {
+			log.Error("HousekeepingUpdate: get repository meta %q: %v", repoLink, err)
+			continue
+		}
+
+		h := meta.Housekeeping
+		if h == nil {
+			h = new(Housekeeping)
+		}
+		fsck := fsckInterval > 0 && now.Sub(h.LastFsck) >= fsckInterval
+		if fsck || h.LastPush.After(h.LastRun) {
+			AddHousekeeping(repoLink, fsck)
+		}
+	}
+}
+
+// RunHousekeeping maintains repositories in the queue, it blocks until the queue is closed.
+func RunHousekeeping() {
+	for repoLink := range HousekeepingQueue.Queue() {
+		log.Trace("RunHousekeeping [repo: %s]", repoLink)
+		fsck := fsckTable.IsRunning(repoLink)
+		if err := Housekeep(repoLink, fsck); err != nil {
+			log.Error("RunHousekeeping [repo: %s]: %v", repoLink, err)
+		}
+		fsckTable.Stop(repoLink)
+		HousekeepingQueue.Remove(repoLink)
+	}
+}
+
+// InitHousekeeping starts background maintenance of repositories.
+func InitHousekeeping() {
+	go RunHousekeeping()
+	if conf.Housekeeping.Interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			HousekeepingUpdate()
+			time.Sleep(time.Duration(conf.Housekeeping.Interval) * time.Minute)
+		}
+	}()
+}
+
+func HousekeepingStatus(c *context.Context) {
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+
+	type result struct {
+		*Housekeeping
+		IsQueued bool
+	}
+	h := meta.Housekeeping
+	if h == nil {
+		h = new(Housekeeping)
+	}
+	c.JSON(200, _type.SuccessResult(result{
+		Housekeeping: h,
+		IsQueued:     HousekeepingQueue.Exist(c.Repo.RepoLink),
+	}))
+}
+
+func HousekeepingPost(c *context.Context) {
+	go AddHousekeeping(c.Repo.RepoLink, c.QueryBool("fsck"))
+	c.JSON(200, _type.SuccessResult("success"))
+}
@@ -0,0 +1,37 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHousekeep(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	require.NoError(t, recordPush("alice/repo"))
+	require.NoError(t, Housekeep("alice/repo", true))
+	meta, err := GetRepoMeta("alice/repo")
+	require.NoError(t, err)
+	require.NotNil(t, meta.Housekeeping)
+	assert.True(t, meta.Housekeeping.LastRun.After(meta.Housekeeping.LastPush))
+	assert.False(t, meta.Housekeeping.LastFsck.IsZero())
+	assert.Empty(t, meta.Housekeeping.LastError)
+	assert.Empty(t, meta.Housekeeping.FsckError)
+	assert.FileExists(t, filepath.Join(repoPath("alice/repo"), "objects", "info", "commit-graph"))
+
+	// A missing object is reported by fsck.
+	newTestRepo(t, "alice/broken")
+	blob := strings.TrimSpace(runGit(t, repoPath("alice/broken"), "rev-parse", "HEAD:README.md"))
+	require.NoError(t, os.Remove(filepath.Join(repoPath("alice/broken"), "objects", blob[:2], blob[2:])))
+	assert.Error(t, Housekeep("alice/broken", true))
+	meta, err = GetRepoMeta("alice/broken")
+	require.NoError(t, err)
+	require.NotNil(t, meta.Housekeeping)
+	assert.NotEmpty(t, meta.Housekeeping.FsckError)
+}
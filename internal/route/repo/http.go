@@ -9,9 +9,12 @@ import (
 	"compress/gzip"
 	"fmt"
 	"git-server/internal/auth"
+	"git-server/internal/context"
 	"git-server/internal/lazyregexp"
 	"git-server/internal/pathutil"
+	"git-server/internal/repoutil"
 	"git-server/internal/tool"
+	"git-server/internal/type"
 	"net/http"
 	"os"
 	"os/exec"
@@ -41,6 +44,78 @@ func askCredentials(c *macaron.Context, status int, text string) {
 	c.Error(status, text)
 }
 
+// authorizeBasic authenticates the user by HTTP Basic Authentication and checks
+// whether the user has access to repositories of the owner. It writes the response
+// and returns false if not.
+func authorizeBasic(c *macaron.Context, ownerName string) bool {
+	authHead := c.Req.Header.Get("Authorization")
+	if authHead == "" {
+		askCredentials(c, http.StatusUnauthorized, "")
+		return false
+	}
+
+	auths := strings.Fields(authHead)
+	if len(auths) != 2 || auths[0] != "Basic" {
+		askCredentials(c, http.StatusUnauthorized, "")
+		return false
+	}
+	authUsername, authPassword, err := tool.BasicAuthDecode(auths[1])
+	if err != nil {
+		askCredentials(c, http.StatusUnauthorized, "")
+		return false
+	}
+	authUser, err := auth.Authenticator.Authenticate(authUsername, authPassword)
+	if err == nil && authUser.FullName != "" {
+		// authorize
+		if flag, _ := auth.Authorizer.Authorize(authUser, ownerName); !flag {
+			c.Status(http.StatusUnauthorized)
+			log.Error("Failed to authenticate user [name: %s]: %v", authUsername, err)
+			return false
+		}
+	} else {
+		c.Status(http.StatusUnauthorized)
+		log.Error("Failed to authenticate user [name: %s]: %v", authUsername, err)
+		return false
+	}
+	return true
+}
+
+// MustHaveReadAccess requires credentials of users who have access to the
+// repository if it is private, public repositories are readable by anyone.
+func MustHaveReadAccess(c *context.Context) {
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	if meta.IsPrivate {
+		authorizeBasic(c.Context, c.Params(":username"))
+	}
+}
+
+// canReadRepo checks whether the user has read access to the repository by
+// given link like MustHaveReadAccess does for the repository of the request.
+// It writes the response and returns false if not.
+func canReadRepo(c *context.Context, repoLink string) bool {
+	owner, name, _ := strings.Cut(repoLink, "/")
+	if err := validateRepoName(owner, name); err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return false
+	}
+	meta, err := GetRepoMeta(repoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return false
+	}
+	return !meta.IsPrivate || authorizeBasic(c.Context, owner)
+}
+
+// MustHaveWriteAccess requires credentials of users who have access to the
+// repository regardless of its visibility.
+func MustHaveWriteAccess(c *context.Context) {
+	authorizeBasic(c.Context, c.Params(":username"))
+}
+
 func HTTPContexter() macaron.Handler {
 	return func(c *macaron.Context) {
 		ownerName := c.Params(":username")
@@ -57,35 +132,28 @@ func HTTPContexter() macaron.Handler {
 			return
 		}
 
-		// Handle HTTP Basic Authentication
-		authHead := c.Req.Header.Get("Authorization")
-		if authHead == "" {
-			askCredentials(c, http.StatusUnauthorized, "")
+		isPush := c.Query("service") == "git-receive-pack" ||
+			strings.HasSuffix(action, "git-receive-pack")
+		meta, err := GetRepoMeta(repoutil.FullRepoName(ownerName, repoName))
+		if err != nil {
+			log.Error("Failed to get repository meta [repo: %s/%s]: %v", ownerName, repoName, err)
+			c.Status(http.StatusInternalServerError)
 			return
 		}
 
-		auths := strings.Fields(authHead)
-		if len(auths) != 2 || auths[0] != "Basic" {
-			askCredentials(c, http.StatusUnauthorized, "")
+		// Anonymous read access is allowed for public repositories.
+		if (isPush || meta.IsPrivate) && !authorizeBasic(c, ownerName) {
 			return
 		}
-		authUsername, authPassword, err := tool.BasicAuthDecode(auths[1])
-		if err != nil {
-			askCredentials(c, http.StatusUnauthorized, "")
-			return
-		}
-		authUser, err := auth.Authenticator.Authenticate(authUsername, authPassword)
-		if err == nil && authUser.FullName != "" {
-			// authorize
-			if flag, _ := auth.Authorizer.Authorize(authUser, ownerName); !flag {
-				c.Status(http.StatusUnauthorized)
-				log.Error("Failed to authenticate user [name: %s]: %v", authUsername, err)
+
+		if isPush {
+			if meta.IsMirror() {
+				c.Error(http.StatusForbidden, "Mirror repository is read-only")
+				return
+			} else if meta.IsArchived {
+				c.Error(http.StatusForbidden, "Archived repository is read-only")
 				return
 			}
-		} else {
-			c.Status(http.StatusUnauthorized)
-			log.Error("Failed to authenticate user [name: %s]: %v", authUsername, err)
-			return
 		}
 
 		c.Map(&HTTPContext{
@@ -198,6 +266,7 @@ const (
 	ENV_REPO_ID                = "GOGS_REPO_ID"
 	ENV_REPO_NAME              = "GOGS_REPO_NAME"
 	ENV_REPO_CUSTOM_HOOKS_PATH = "GOGS_REPO_CUSTOM_HOOKS_PATH"
+	ENV_CUSTOM_CONF            = "GOGS_CUSTOM_CONF"
 )
 
 func ComposeHookEnvs(opts ComposeHookEnvsOptions) []string {
@@ -206,6 +275,7 @@ func ComposeHookEnvs(opts ComposeHookEnvsOptions) []string {
 		ENV_REPO_OWNER_NAME + "=" + opts.OwnerName,
 		ENV_REPO_NAME + "=" + opts.RepoName,
 		ENV_REPO_CUSTOM_HOOKS_PATH + "=" + filepath.Join(opts.RepoPath, "custom_hooks"),
+		ENV_CUSTOM_CONF + "=" + conf.CustomConf,
 	}
 	return envs
 }
@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+)
+
+func TestHTTPContexter_AnonymousRead(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/public")
+	newTestRepo(t, "alice/private")
+	require.NoError(t, UpdateRepoMeta("alice/public", func(meta *RepoMeta) {
+		meta.IsPrivate = false
+	}))
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Route("/:username/:reponame/*", "GET,POST", HTTPContexter(), func(c *HTTPContext) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		wantStatus int
+	}{
+		{"clone public", "GET", "/alice/public.git/info/refs?service=git-upload-pack", http.StatusOK},
+		{"fetch public", "POST", "/alice/public.git/git-upload-pack", http.StatusOK},
+		{"push public", "GET", "/alice/public.git/info/refs?service=git-receive-pack", http.StatusUnauthorized},
+		{"clone private", "GET", "/alice/private.git/info/refs?service=git-upload-pack", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, test.url, nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantStatus, resp.Code)
+			if test.wantStatus == http.StatusUnauthorized {
+				assert.NotEmpty(t, resp.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/osutil"
+)
+
+// RepoMeta contains information of a repository that is not tracked by Git,
+// it is persisted as a JSON file inside the bare repository.
+type RepoMeta struct {
+	Description string `json:",omitempty"`
+	Website     string `json:",omitempty"`
+	IsPrivate   bool
+	IsArchived  bool
+	Created     time.Time
+	// The full name (i.e. "<owner>/<name>") of the repository this one is forked from.
+	ForkFrom string `json:",omitempty"`
+	// The pull mirror settings and status, nil if the repository is not a mirror.
+	Mirror *Mirror `json:",omitempty"`
+	// The downstream remotes that the repository is pushed to.
+	PushMirrors []*PushMirror `json:",omitempty"`
+	// The status of maintenance tasks, nil if never pushed or maintained.
+	Housekeeping *Housekeeping `json:",omitempty"`
+	// Branches deleted through the API that can be restored.
+	DeletedBranches []*DeletedBranch `json:",omitempty"`
+}
+
+// IsFork returns true if the repository is forked from another repository.
+func (m *RepoMeta) IsFork() bool {
+	return m.ForkFrom != ""
+}
+
+// IsMirror returns true if the repository is a pull mirror.
+func (m *RepoMeta) IsMirror() bool {
+	return m.Mirror != nil
+}
+
+// RepoMetaPath returns the path of metadata file of the repository in given path.
+func RepoMetaPath(repoPath string) string {
+	return filepath.Join(repoPath, "meta.json")
+}
+
+func readRepoMeta(metaPath string) (*RepoMeta, error) {
+	meta := new(RepoMeta)
+	if !osutil.IsFile(metaPath) {
+		// Repositories without metadata are not exposed to anonymous users.
+		meta.IsPrivate = true
+		return meta, nil
+	}
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %v", err)
+	}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("unmarshal: %v", err)
+	}
+	return meta, nil
+}
+
+// GetRepoMeta returns metadata of the repository by given repository link,
+// a private one is returned if nothing has been persisted yet.
+func GetRepoMeta(repoLink string) (*RepoMeta, error) {
+	metaPath := RepoMetaPath(repoPath(repoLink))
+
+	repoWorkingPool.CheckIn(metaPath)
+	defer repoWorkingPool.CheckOut(metaPath)
+
+	return readRepoMeta(metaPath)
+}
+
+// UpdateRepoMeta loads metadata of the repository by given repository link,
+// calls update to modify it and persists the result.
+func UpdateRepoMeta(repoLink string, update func(meta *RepoMeta)) error {
+	metaPath := RepoMetaPath(repoPath(repoLink))
+
+	repoWorkingPool.CheckIn(metaPath)
+	defer repoWorkingPool.CheckOut(metaPath)
+
+	meta, err := readRepoMeta(metaPath)
+	if err != nil {
+		return err
+	}
+	update(meta)
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+	if err = os.WriteFile(metaPath, data, 0644); err != nil {
+		return fmt.Errorf("write file: %v", err)
+	}
+	return nil
+}
+
+// InitRepoMeta persists metadata of repositories that have none, e.g. those
+// created before metadata existed. They are private as before, which is now
+// recorded explicitly and can be changed by repository settings.
+func InitRepoMeta() {
+	repos, err := getAllRepos()
+	if err != nil {
+		log.Error("InitRepoMeta: list repositories: %v", err)
+		return
+	}
+	for _, r := range repos {
+		repoLink := strings.TrimSuffix(r, ".git")
+		if osutil.IsFile(RepoMetaPath(repoPath(repoLink))) {
+			continue
+		}
+		if err = UpdateRepoMeta(repoLink, func(*RepoMeta) {}); err != nil {
+			log.Error("InitRepoMeta [repo: %s]: %v", repoLink, err)
+			continue
+		}
+		log.Warn("InitRepoMeta [repo: %s]: no metadata found, the repository is made private", repoLink)
+	}
+}
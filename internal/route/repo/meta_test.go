@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-macaron/binding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/context"
+	"git-server/internal/form"
+)
+
+func TestGetRepoInfo(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+
+	require.NoError(t, UpdateRepoMeta("alice/repo", func(meta *RepoMeta) {
+		meta.Description = "A test repository"
+		meta.IsPrivate = true
+		meta.IsArchived = true
+	}))
+
+	info, err := GetRepoInfo("alice/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", info.Owner)
+	assert.Equal(t, "repo", info.Name)
+	assert.Equal(t, "A test repository", info.Description)
+	assert.True(t, info.IsPrivate)
+	assert.True(t, info.IsArchived)
+	assert.False(t, info.IsFork)
+	assert.Equal(t, "master", info.DefaultBranch)
+	assert.False(t, info.Created.IsZero())
+}
+
+func TestSettingsPost(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	require.NoError(t, UpdateRepoMeta("alice/repo", func(meta *RepoMeta) {
+		meta.Description = "A test repository"
+		meta.Website = "https://example.com"
+		meta.IsPrivate = true
+	}))
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Post("/settings", func(c *context.Context) {
+		c.Repo.RepoLink = "alice/repo"
+	}, binding.BindIgnErr(form.RepoSetting{}), SettingsPost)
+	post := func(body string) {
+		req, err := http.NewRequest("POST", "/settings", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	}
+
+	// Fields not sent are left unchanged.
+	post(`{"Description": "Updated"}`)
+	meta, err := GetRepoMeta("alice/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "Updated", meta.Description)
+	assert.Equal(t, "https://example.com", meta.Website)
+	assert.True(t, meta.IsPrivate)
+
+	post(`{"Website": "", "Private": false}`)
+	meta, err = GetRepoMeta("alice/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "Updated", meta.Description)
+	assert.Empty(t, meta.Website)
+	assert.False(t, meta.IsPrivate)
+}
+
+func TestInitRepoMeta(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/legacy")
+	newTestRepo(t, "alice/public")
+	require.NoError(t, UpdateRepoMeta("alice/public", func(meta *RepoMeta) {
+		meta.IsPrivate = false
+	}))
+
+	InitRepoMeta()
+	assert.FileExists(t, RepoMetaPath(repoPath("alice/legacy")))
+	meta, err := GetRepoMeta("alice/legacy")
+	require.NoError(t, err)
+	assert.True(t, meta.IsPrivate)
+
+	meta, err = GetRepoMeta("alice/public")
+	require.NoError(t, err)
+	assert.False(t, meta.IsPrivate, "existing metadata is kept")
+
+	// Visibility of the migrated repository can be changed like any other.
+	require.NoError(t, UpdateRepoMeta("alice/legacy", func(meta *RepoMeta) {
+		meta.IsPrivate = false
+	}))
+	InitRepoMeta()
+	data, err := os.ReadFile(RepoMetaPath(repoPath("alice/legacy")))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"IsPrivate": false`)
+}
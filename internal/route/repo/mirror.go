@@ -0,0 +1,381 @@
+package repo
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/cryptoutil"
+	"git-server/internal/form"
+	"git-server/internal/osutil"
+	processed "git-server/internal/process"
+	"git-server/internal/repoutil"
+	"git-server/internal/sync"
+	"git-server/internal/type"
+)
+
+// Mirror contains settings and synchronization status of a pull mirror.
+type Mirror struct {
+	// The remote address with credentials stripped.
+	Address  string
+	Username string
+	// The password encrypted by the secret key, in base64 format.
+	EncryptedPassword string
+	// Interval in hours between each synchronization.
+	Interval  int
+	LastSync  time.Time
+	NextSync  time.Time
+	LastError string
+}
+
+// MirrorQueue holds repository links of mirrors to be synchronized.
+var MirrorQueue = sync.NewUniqueQueue(1000)
+
+// taskStatusTable maintains running status of background tasks.
+var taskStatusTable = sync.NewStatusTable()
+
+const taskMirrorUpdate = "mirror_update"
+
+// sanitizeURL removes credentials of given remote address.
+func sanitizeURL(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.User == nil {
+		return addr
+	}
+	u.User = nil
+	return u.String()
+}
+
+// ParseRemoteAddr returns the remote address with credentials stripped, and
+// the credentials given or embedded in the address. Local paths are only
+// allowed when enabled by the configuration.
+func ParseRemoteAddr(addr, username, password string) (_, _, _ string, err error) {
+	addr = strings.TrimSpace(addr)
+	if strings.HasPrefix(addr, "http://") ||
+		strings.HasPrefix(addr, "https://") ||
+		strings.HasPrefix(addr, "git://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", "", "", errors.Errorf("invalid clone address %q: %v", sanitizeURL(addr), err)
+		}
+		if u.User != nil && username == "" && password == "" {
+			username = u.User.Username()
+			password, _ = u.User.Password()
+		}
+		u.User = nil
+		return u.String(), username, password, nil
+	}
+
+	if !conf.Repository.EnableLocalPathMigration {
+		return "", "", "", errors.Errorf("invalid clone address %q: local path migration is disabled", addr)
+	} else if !osutil.IsDir(addr) {
+		return "", "", "", errors.Errorf("invalid clone address %q: local path does not exist", addr)
+	}
+	return addr, "", "", nil
+}
+
+// encryptPassword returns the password encrypted by the secret key in base64
+// format, or empty if the password is empty.
+func encryptPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	encrypted, err := cryptoutil.AESGCMEncrypt(secretKey(), []byte(password))
+	if err != nil {
+		return "", fmt.Errorf("encrypt password: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// decryptPassword returns the password encrypted by encryptPassword.
+func decryptPassword(encrypted string) (string, error) {
+	if encrypted == "" {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("decode password: %v", err)
+	}
+	decrypted, err := cryptoutil.AESGCMDecrypt(secretKey(), data)
+	if err != nil {
+		return "", fmt.Errorf("decrypt password: %v", err)
+	}
+	return string(decrypted), nil
+}
+
+// credentialHelper answers git with credentials of the remote from environment
+// variables set by authEnv.
+const credentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$GIT_REMOTE_USERNAME" "$GIT_REMOTE_PASSWORD"; }; f`
+
+// authEnv returns environment variables of git to authenticate to the HTTP
+// remote with the credentials, which are neither stored in the config of the
+// repository nor visible in arguments of the command. The credential helper is
+// scoped to the host of the remote so credentials are never sent to others,
+// e.g. when redirected.
+func authEnv(remoteAddr, username, password string) []string {
+	if username == "" && password == "" {
+		return nil
+	}
+	u, err := url.Parse(remoteAddr)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=credential." + u.Scheme + "://" + u.Host + ".helper",
+		"GIT_CONFIG_VALUE_0=" + credentialHelper,
+		"GIT_REMOTE_USERNAME=" + username,
+		"GIT_REMOTE_PASSWORD=" + password,
+	}
+}
+
+type MigrateRepoOptions struct {
+	RepoLink  string
+	CloneAddr string // Remote address without credentials
+	Username  string
+	Password  string
+	IsMirror  bool
+	Interval  int // In hours
+}
+
+// MigrateRepository creates a new repository from a remote address. The repository
+// keeps the remote to be synchronized periodically if it is a mirror, otherwise
+// the remote is removed after cloning.
+func MigrateRepository(opts MigrateRepoOptions) (err error) {
+	repoPath := repoPath(opts.RepoLink)
+	if repoExists(repoPath) {
+		return errors.Errorf("repository %q already exists", opts.RepoLink)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(repoPath), os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(repoPath)
+		}
+	}()
+
+	var stderr string
+	if _, stderr, err = processed.ExecDirEnv(gitTimeout(conf.Git.Timeout.Migrate), "",
+		fmt.Sprintf("MigrateRepository (git clone): %s", opts.RepoLink),
+		authEnv(opts.CloneAddr, opts.Username, opts.Password),
+		"git", "clone", "--mirror", "--quiet", "--", opts.CloneAddr, repoPath); err != nil {
+		return fmt.Errorf("git clone: %v - %s", err, stderr)
+	}
+
+	if err = createDelegateHooks(repoPath); err != nil {
+		return fmt.Errorf("createDelegateHooks: %v", err)
+	}
+
+	if !opts.IsMirror {
+		if _, stderr, err = processed.ExecDir(-1, repoPath,
+			fmt.Sprintf("MigrateRepository (git remote rm): %s", opts.RepoLink),
+			"git", "remote", "rm", "origin"); err != nil {
+			return fmt.Errorf("git remote rm: %v - %s", err, stderr)
+		}
+		return nil
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = conf.Mirror.DefaultInterval
+	}
+	encryptedPassword, err := encryptPassword(opts.Password)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err = UpdateRepoMeta(opts.RepoLink, func(meta *RepoMeta) {
+		meta.Mirror = &Mirror{
+			Address:           opts.CloneAddr,
+			Username:          opts.Username,
+			EncryptedPassword: encryptedPassword,
+			Interval:          interval,
+			LastSync:          now,
+			NextSync:          now.Add(time.Duration(interval) * time.Hour),
+		}
+	}); err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	}
+	return nil
+}
+
+func MigratePost(c *context.Context, f form.MigrateRepo) {
+	if err := validateRepoName(f.Code, f.RepoName); err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	if !authorizeBasic(c.Context, f.Code) {
+		return
+	}
+
+	cloneAddr, username, password, err := ParseRemoteAddr(f.CloneAddr, f.AuthUsername, f.AuthPassword)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+
+	if err = MigrateRepository(MigrateRepoOptions{
+		RepoLink:  repoutil.FullRepoName(f.Code, f.RepoName),
+		CloneAddr: cloneAddr,
+		Username:  username,
+		Password:  password,
+		IsMirror:  f.Mirror,
+		Interval:  f.Interval,
+	}); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(repoutil.CloneLink{
+		HTTPS: repoutil.HTTPSCloneURL(f.Code, f.RepoName),
+	}))
+}
+
+// SyncMirror fetches latest changes of the mirror from its remote, and records
+// the result of synchronization.
+func SyncMirror(repoLink string) error {
+	meta, err := GetRepoMeta(repoLink)
+	if err != nil {
+		return fmt.Errorf("get repository meta: %v", err)
+	} else if !meta.IsMirror() {
+		return errors.Errorf("repository %q is not a mirror", repoLink)
+	}
+
+	password, syncErr := decryptPassword(meta.Mirror.EncryptedPassword)
+	if syncErr == nil {
+		var stderr string
+		if _, stderr, syncErr = processed.ExecDirEnv(gitTimeout(conf.Git.Timeout.Mirror), repoPath(repoLink),
+			fmt.Sprintf("SyncMirror (git fetch --prune): %s", repoLink),
+			authEnv(meta.Mirror.Address, meta.Mirror.Username, password),
+			"git", "fetch", "--prune", "origin"); syncErr != nil {
+			syncErr = fmt.Errorf("git fetch --prune: %v - %s", syncErr, stderr)
+		}
+	}
+
+	now := time.Now()
+	if err = UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		if meta.Mirror == nil {
+			return
+		}
+		meta.Mirror.LastSync = now
+		meta.Mirror.NextSync = now.Add(time.Duration(meta.Mirror.Interval) * time.Hour)
+		meta.Mirror.LastError = ""
+		if syncErr != nil {
+			meta.Mirror.LastError = syncErr.Error()
+		}
+	}); err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	}
+	return syncErr
+}
+
+// MirrorUpdate checks and queues mirrors that are due to be synchronized.
+func MirrorUpdate() {
+	if taskStatusTable.IsRunning(taskMirrorUpdate) {
+		return
+	}
+	taskStatusTable.Start(taskMirrorUpdate)
+	defer taskStatusTable.Stop(taskMirrorUpdate)
+
+	log.Trace("Doing: MirrorUpdate")
+
+	repos, err := getAllRepos()
+	if err != nil {
+		log.Error("MirrorUpdate: list repositories: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, r := range repos {
+		repoLink := strings.TrimSuffix(r, ".git")
+		meta, err := GetRepoMeta(repoLink)
+		if err != nil {
+			log.Error("MirrorUpdate: get repository meta %q: %v", repoLink, err)
+			continue
+		}
+		if meta.IsMirror() && !meta.Mirror.NextSync.After(now) {
+			MirrorQueue.Add(repoLink)
+		}
+	}
+}
+
+// SyncMirrors synchronizes mirrors in the queue, it blocks until the queue is closed.
+func SyncMirrors() {
+	for repoLink := range MirrorQueue.Queue() {
+		log.Trace("SyncMirrors [repo: %s]", repoLink)
+		if err := SyncMirror(repoLink); err != nil {
+			log.Error("SyncMirrors [repo: %s]: %v", repoLink, err)
+		}
+		MirrorQueue.Remove(repoLink)
+	}
+}
+
+// InitSyncMirrors starts background synchronization of pull and push mirrors.
+func InitSyncMirrors() {
+	go SyncMirrors()
+	go func() {
+		for {
+			MirrorUpdate()
+			PushMirrorUpdate()
+			time.Sleep(time.Minute)
+		}
+	}()
+}
+
+func MirrorStatus(c *context.Context) {
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	} else if !meta.IsMirror() {
+		c.JSON(500, _type.FaildResult(errors.New("repository is not a mirror")))
+		return
+	}
+
+	type result struct {
+		*Mirror
+		IsSyncing bool
+	}
+	c.JSON(200, _type.SuccessResult(result{
+		Mirror:    meta.Mirror,
+		IsSyncing: MirrorQueue.Exist(c.Repo.RepoLink),
+	}))
+}
+
+// MustBeNotMirror rejects the request if the repository is a pull mirror,
+// which only changes by synchronization from its remote.
+func MustBeNotMirror(c *context.Context) {
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	if meta.IsMirror() {
+		c.JSON(403, _type.FaildResult(errors.New("repository is a mirror")))
+		return
+	}
+}
+
+func MirrorSyncPost(c *context.Context) {
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	} else if !meta.IsMirror() {
+		c.JSON(500, _type.FaildResult(errors.New("repository is not a mirror")))
+		return
+	}
+
+	go MirrorQueue.Add(c.Repo.RepoLink)
+	c.JSON(200, _type.SuccessResult("success"))
+}
@@ -42,11 +42,33 @@ type NumInfo struct {
 	NumFiles   int
 }
 
+// checkPullRequestRepos resolves repositories referenced by the pull request
+// to their current links, and checks the user has read access to those other
+// than the repository of the request. It writes the response and returns false
+// if not.
+func checkPullRequestRepos(c *context.Context, f *form.PullRequest) bool {
+	resolvePullRequestRepos(f)
+	for _, link := range []string{f.HeadRepo, f.BaseRepo} {
+		if link != "" && link != c.Repo.RepoLink && !canReadRepo(c, link) {
+			return false
+		}
+	}
+	return true
+}
+
 func MergePullRequest(c *context.Context, f form.MergePullRequest) {
 	var (
 		MergedCommitID string
 		err            error
 	)
+	if !checkPullRequestRepos(c, &f.Pull) {
+		return
+	}
+	for i := range f.Pulls {
+		if !checkPullRequestRepos(c, &f.Pulls[i]) {
+			return
+		}
+	}
 	if MergedCommitID, err = Merge(f.Pull, c.Repo.GitRepo, MergeStyle(c.Query("merge_style")), c.Query("commit_description")); err != nil {
 		c.JSON(500, _type.FaildResult(err))
 		return
@@ -79,13 +101,14 @@ func Merge(f form.PullRequest, baseGitRepo *git.Repository, mergeStyle MergeStyl
 	var err error
 	// Create temporary directory to store temporary copy of the base repository,
 	// and clean it up when operation finished regardless of succeed or not.
-	tmpBasePath := filepath.Join(conf.Repository.LocalPath, "data", com.ToStr(time.Now().Nanosecond())+".git")
-	if err := os.MkdirAll(filepath.Dir(tmpBasePath), os.ModePerm); err != nil {
+	tmpPath, err := createTempDir("merge-")
+	if err != nil {
 		return "", err
 	}
 	defer func() {
-		_ = os.RemoveAll(filepath.Dir(tmpBasePath))
+		_ = os.RemoveAll(tmpPath)
 	}()
+	tmpBasePath := filepath.Join(tmpPath, "base.git")
 
 	// Clone the base repository to the defined temporary directory,
 	// and checks out to base branch directly.
@@ -247,8 +270,9 @@ func CompareAndPullRequest(c *context.Context) {
 func ParseCompareInfo(c *context.Context) (*git.Repository, *gitutil.PullRequestMeta, string, error) {
 
 	// Get compared branches information
-	// format: <base branch>...[<head repo>:]<head branch>
+	// format: <base branch>...[<head user>:[<head repo>:]]<head branch>
 	// base<-head: master...head:feature
+	// base<-fork: master...head:repo:feature
 	// same repo: master...feature
 	//infos := strings.Split(c.Params("*"), "...")
 	//
@@ -275,6 +299,17 @@ func ParseCompareInfo(c *context.Context) (*git.Repository, *gitutil.PullRequest
 		isSameRepo = true
 		headBranch = headInfos[0]
 
+	} else if len(headInfos) == 2 {
+		// Head repository is the one of head user in the same fork relation.
+		forkLink, err := findForkByOwner(c.Repo.RepoLink, headInfos[0])
+		if err != nil {
+			return nil, nil, "", err
+		}
+		headUser, headPepo = path.Split(forkLink)
+		headUser = strings.TrimSuffix(headUser, "/")
+		headBranch = headInfos[1]
+		isSameRepo = forkLink == c.Repo.RepoLink
+
 	} else if len(headInfos) == 3 {
 		headUser = headInfos[0]
 		headPepo = headInfos[1]
@@ -370,10 +405,11 @@ func PrepareCompareDiff(
 		return true, nil, nil, nil
 	}
 
-	diff, err := gitutil.RepoDiff(headGitRepo,
-		headCommitID, conf.Git.MaxDiffFiles, conf.Git.MaxDiffLines, conf.Git.MaxDiffLineChars,
-		git.DiffOptions{Base: meta.MergeBase, Timeout: time.Duration(conf.Git.Timeout.Diff) * time.Second},
-	)
+	opts, err := queryDiffOptions(c)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	diff, err := getDiff(headGitRepo.Path(), meta.MergeBase, headCommitID, queryDiffLimits(c, defaultDiffLimits()), opts)
 	if err != nil {
 		return false, nil, nil, errors.New("get repository diff err")
 	}
@@ -398,6 +434,9 @@ func ViewPullCommits(c *context.Context, f form.PullRequest) {
 		numInfo NumInfo
 		err     error
 	)
+	if !checkPullRequestRepos(c, &f) {
+		return
+	}
 
 	if f.HasMerged {
 		numInfo, err = PrepareMergedViewPullInfo(c, f)
@@ -545,10 +584,27 @@ func SavePatch(index int, patch []byte, repoLink string) error {
 	if err = os.MkdirAll(filepath.Dir(patchPath), os.ModePerm); err != nil {
 		return err
 	}
-	if err = os.WriteFile(patchPath, patch, 0644); err != nil {
+
+	// Write to a temporary file first so an interrupted write never leaves a
+	// partial patch, leftovers are removed on startup.
+	f, err := os.CreateTemp(filepath.Dir(patchPath), filepath.Base(patchPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("CreateTemp: %v", err)
+	}
+	_, err = f.Write(patch)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), patchPath)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
 		return fmt.Errorf("WriteFile: %v", err)
 	}
-
 	return nil
 }
 
@@ -559,7 +615,6 @@ func PatchPath(index int, repoLink string) (string, error) {
 }
 
 // testPatch checks if patch can be merged to base repository without conflict.
-// FIXME: make a mechanism to clean up stable local copies.
 func testPatch(index int, repoLink string, baseBranch string) (PullRequestStatus, error) {
 
 	patchPath, err := PatchPath(index, repoLink)
@@ -569,14 +624,14 @@ func testPatch(index int, repoLink string, baseBranch string) (PullRequestStatus
 
 	// Fast fail if patch does not exist, this assumes data is corrupted.
 	if !osutil.IsFile(patchPath) {
-		log.Trace("PullRequest[%d].testPatch: ignored corrupted data")
+		log.Trace("PullRequest[%d].testPatch: ignored corrupted data", index)
 		return -1, nil
 	}
 
 	repoWorkingPool.CheckIn(com.ToStr(repoLink))
 	defer repoWorkingPool.CheckOut(com.ToStr(repoLink))
 
-	log.Trace("PullRequest[%d].testPatch (patchPath): %s", "", patchPath)
+	log.Trace("PullRequest[%d].testPatch (patchPath): %s", index, patchPath)
 
 	if err := UpdateLocalCopyBranch(repoLink, baseBranch); err != nil {
 		return -1, fmt.Errorf("UpdateLocalCopy [%d]: %v", 1, err)
@@ -604,6 +659,9 @@ func PrepareViewPullInfo(c *context.Context, f form.PullRequest) {
 		numInfo NumInfo
 		err     error
 	)
+	if !checkPullRequestRepos(c, &f) {
+		return
+	}
 	if f.HasMerged {
 		numInfo, err = PrepareMergedViewPullInfo(c, f)
 		if err != nil {
@@ -657,66 +715,59 @@ func ViewPullInfo(f form.PullRequest) (*gitutil.PullRequestMeta, error) {
 	}
 	return prMeta, nil
 }
-func ViewPullFiles(c *context.Context, f form.PullRequest) {
-	var (
-		diffGitRepo   *git.Repository
-		startCommitID string
-		endCommitID   string
-		gitRepo       *git.Repository
-		numInfo       NumInfo
-		err           error
-	)
 
+// pullDiffRange returns the repository and the range of commits to diff of the
+// pull request, along with numbers of its commits and files.
+func pullDiffRange(c *context.Context, f form.PullRequest) (gitRepo *git.Repository, startCommitID, endCommitID string, numInfo NumInfo, err error) {
 	if f.HasMerged {
 		numInfo, err = PrepareMergedViewPullInfo(c, f)
 		if err != nil {
-			c.JSON(500, _type.FaildResult(err))
-			return
-		}
-
-		diffGitRepo = c.Repo.GitRepo
-		startCommitID = f.MergeBase
-		endCommitID = f.MergeCommitId
-		gitRepo = c.Repo.GitRepo
-	} else {
-		prInfo, err := ViewPullInfo(f)
-		if err != nil {
-			c.JSON(500, _type.FaildResult(err))
-			return
-		}
-		if prInfo == nil {
-			c.JSON(500, _type.FaildResult(errors.New("Not Found")))
-			return
-		}
-		numInfo = NumInfo{
-			NumCommits: len(prInfo.Commits),
-			NumFiles:   prInfo.NumFiles,
+			return nil, "", "", numInfo, err
 		}
+		return c.Repo.GitRepo, f.MergeBase, f.MergeCommitId, numInfo, nil
+	}
 
-		headRepoPath := filepath.Join(conf.Repository.Root, f.HeadRepo) + ".git"
+	prInfo, err := ViewPullInfo(f)
+	if err != nil {
+		return nil, "", "", numInfo, err
+	}
+	if prInfo == nil {
+		return nil, "", "", numInfo, errors.New("Not Found")
+	}
+	numInfo = NumInfo{
+		NumCommits: len(prInfo.Commits),
+		NumFiles:   prInfo.NumFiles,
+	}
 
-		headGitRepo, err := git.Open(headRepoPath)
-		if err != nil {
-			c.Error(500, "open repository")
-			return
-		}
+	headRepoPath := filepath.Join(conf.Repository.Root, f.HeadRepo) + ".git"
+	headGitRepo, err := git.Open(headRepoPath)
+	if err != nil {
+		return nil, "", "", numInfo, errors.Errorf("open repository: %v", err)
+	}
+	headCommitID, err := headGitRepo.BranchCommitID(f.HeadBranch)
+	if err != nil {
+		return nil, "", "", numInfo, errors.Errorf("get head branch commit ID: %v", err)
+	}
+	return headGitRepo, prInfo.MergeBase, headCommitID, numInfo, nil
+}
 
-		headCommitID, err := headGitRepo.BranchCommitID(f.HeadBranch)
-		if err != nil {
-			c.Error(500, "get head branch commit ID")
-			return
-		}
+func ViewPullFiles(c *context.Context, f form.PullRequest) {
+	if !checkPullRequestRepos(c, &f) {
+		return
+	}
 
-		diffGitRepo = headGitRepo
-		startCommitID = prInfo.MergeBase
-		endCommitID = headCommitID
-		gitRepo = headGitRepo
+	gitRepo, startCommitID, endCommitID, numInfo, err := pullDiffRange(c, f)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
 	}
 
-	diff, err := gitutil.RepoDiff(diffGitRepo,
-		endCommitID, conf.Git.MaxDiffFiles, conf.Git.MaxDiffLines, conf.Git.MaxDiffLineChars,
-		git.DiffOptions{Base: startCommitID, Timeout: time.Duration(conf.Git.Timeout.Diff) * time.Second},
-	)
+	opts, err := queryDiffOptions(c)
+	if err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	diff, err := getDiff(gitRepo.Path(), startCommitID, endCommitID, queryDiffLimits(c, defaultDiffLimits()), opts)
 	if err != nil {
 		c.Error(500, "get diff")
 		return
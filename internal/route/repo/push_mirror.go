@@ -0,0 +1,314 @@
+package repo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+	gouuid "github.com/satori/go.uuid"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/form"
+	"git-server/internal/osutil"
+	processed "git-server/internal/process"
+	"git-server/internal/repoutil"
+	"git-server/internal/sync"
+	"git-server/internal/type"
+)
+
+// PushMirror contains settings and synchronization status of a downstream
+// remote that the repository is pushed to.
+type PushMirror struct {
+	ID string
+	// The remote address with credentials stripped.
+	Address  string
+	Username string
+	// The password encrypted by the secret key, in base64 format.
+	EncryptedPassword string
+	// Interval in hours between each scheduled synchronization, zero to disable.
+	Interval   int
+	SyncOnPush bool
+	LastSync   time.Time
+	NextSync   time.Time
+	LastError  string
+}
+
+// pushMirrorTable maintains push mirrors that are being synchronized.
+var pushMirrorTable = sync.NewStatusTable()
+
+func pushMirrorKey(repoLink, id string) string {
+	return repoLink + ":" + id
+}
+
+// secretKey returns the key derived from the secret key of the configuration.
+func secretKey() []byte {
+	key := sha256.Sum256([]byte(conf.Security.SecretKey))
+	return key[:]
+}
+
+// HookSecret returns the secret for Git hooks of the repository to call back
+// to the server.
+func HookSecret(repoLink string) string {
+	mac := hmac.New(sha256.New, secretKey())
+	mac.Write([]byte(repoLink))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type AddPushMirrorOptions struct {
+	Address    string
+	Username   string
+	Password   string
+	Interval   int // In hours
+	SyncOnPush bool
+}
+
+// AddPushMirror adds a new push mirror to the repository.
+func AddPushMirror(repoLink string, opts AddPushMirrorOptions) (*PushMirror, error) {
+	addr, username, password, err := ParseRemoteAddr(opts.Address, opts.Username, opts.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	mirror := &PushMirror{
+		ID:         gouuid.NewV4().String(),
+		Address:    addr,
+		Username:   username,
+		Interval:   opts.Interval,
+		SyncOnPush: opts.SyncOnPush,
+	}
+	if mirror.EncryptedPassword, err = encryptPassword(password); err != nil {
+		return nil, err
+	}
+	if mirror.Interval > 0 {
+		mirror.NextSync = time.Now().Add(time.Duration(mirror.Interval) * time.Hour)
+	}
+
+	// Repositories created before push mirrors were supported may not have the hook.
+	if mirror.SyncOnPush && !osutil.IsFile(filepath.Join(repoPath(repoLink), "hooks", string(git.HookPostReceive))) {
+		if err = createDelegateHook(repoPath(repoLink), git.HookPostReceive); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		meta.PushMirrors = append(meta.PushMirrors, mirror)
+	}); err != nil {
+		return nil, fmt.Errorf("update repository meta: %v", err)
+	}
+	return mirror, nil
+}
+
+// DeletePushMirror removes the push mirror by given ID from the repository.
+func DeletePushMirror(repoLink, id string) error {
+	found := false
+	err := UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		for i := range meta.PushMirrors {
+			if meta.PushMirrors[i].ID == id {
+				meta.PushMirrors = append(meta.PushMirrors[:i], meta.PushMirrors[i+1:]...)
+				found = true
+				return
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	} else if !found {
+		return errors.Errorf("push mirror %q does not exist", id)
+	}
+	return nil
+}
+
+// SyncPushMirror pushes all references of the repository to the push mirror by
+// given ID, and records the result of synchronization. It does nothing if the
+// same push mirror is being synchronized.
+func SyncPushMirror(repoLink, id string) error {
+	key := pushMirrorKey(repoLink, id)
+	if !pushMirrorTable.TryStart(key) {
+		return nil
+	}
+	defer pushMirrorTable.Stop(key)
+
+	meta, err := GetRepoMeta(repoLink)
+	if err != nil {
+		return fmt.Errorf("get repository meta: %v", err)
+	}
+	var mirror *PushMirror
+	for i := range meta.PushMirrors {
+		if meta.PushMirrors[i].ID == id {
+			mirror = meta.PushMirrors[i]
+			break
+		}
+	}
+	if mirror == nil {
+		return errors.Errorf("push mirror %q does not exist", id)
+	}
+
+	password, syncErr := decryptPassword(mirror.EncryptedPassword)
+	if syncErr == nil {
+		var stderr string
+		// Only branches and tags are mirrored, leaving out internal references
+		// such as deleted branches kept for restoration.
+		if _, stderr, syncErr = processed.ExecDirEnv(gitTimeout(conf.Git.Timeout.Mirror), repoPath(repoLink),
+			fmt.Sprintf("SyncPushMirror (git push --prune): %s -> %s", repoLink, mirror.Address),
+			authEnv(mirror.Address, mirror.Username, password),
+			"git", "push", "--force", "--prune", "--", mirror.Address,
+			"refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*"); syncErr != nil {
+			syncErr = fmt.Errorf("git push --prune: %v - %s", syncErr, stderr)
+		}
+	}
+
+	now := time.Now()
+	if err = UpdateRepoMeta(repoLink, func(meta *RepoMeta) {
+		for _, m := range meta.PushMirrors {
+			if m.ID != id {
+				continue
+			}
+			m.LastSync = now
+			if m.Interval > 0 {
+				m.NextSync = now.Add(time.Duration(m.Interval) * time.Hour)
+			}
+			m.LastError = ""
+			if syncErr != nil {
+				m.LastError = syncErr.Error()
+			}
+		}
+	}); err != nil {
+		return fmt.Errorf("update repository meta: %v", err)
+	}
+	return syncErr
+}
+
+// syncPushMirrors synchronizes push mirrors of the repository that match the filter.
+func syncPushMirrors(repoLink string, filter func(m *PushMirror) bool) {
+	meta, err := GetRepoMeta(repoLink)
+	if err != nil {
+		log.Error("Failed to get repository meta [repo: %s]: %v", repoLink, err)
+		return
+	}
+
+	for _, m := range meta.PushMirrors {
+		if !filter(m) {
+			continue
+		}
+		if err = SyncPushMirror(repoLink, m.ID); err != nil {
+			log.Error("Failed to sync push mirror [repo: %s, id: %s]: %v", repoLink, m.ID, err)
+		}
+	}
+}
+
+// PushMirrorUpdate synchronizes push mirrors that are due to be synchronized.
+func PushMirrorUpdate() {
+	repos, err := getAllRepos()
+	if err != nil {
+		log.Error("PushMirrorUpdate: list repositories: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, r := range repos {
+		syncPushMirrors(strings.TrimSuffix(r, ".git"), func(m *PushMirror) bool {
+			return m.Interval > 0 && !m.NextSync.After(now)
+		})
+	}
+}
+
+type pushMirrorInfo struct {
+	ID         string
+	Address    string
+	Username   string
+	Interval   int
+	SyncOnPush bool
+	LastSync   time.Time
+	NextSync   time.Time
+	LastError  string
+	IsSyncing  bool
+}
+
+func newPushMirrorInfo(repoLink string, m *PushMirror) pushMirrorInfo {
+	return pushMirrorInfo{
+		ID:         m.ID,
+		Address:    m.Address,
+		Username:   m.Username,
+		Interval:   m.Interval,
+		SyncOnPush: m.SyncOnPush,
+		LastSync:   m.LastSync,
+		NextSync:   m.NextSync,
+		LastError:  m.LastError,
+		IsSyncing:  pushMirrorTable.IsRunning(pushMirrorKey(repoLink, m.ID)),
+	}
+}
+
+func PushMirrors(c *context.Context) {
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+
+	mirrors := make([]pushMirrorInfo, 0, len(meta.PushMirrors))
+	for _, m := range meta.PushMirrors {
+		mirrors = append(mirrors, newPushMirrorInfo(c.Repo.RepoLink, m))
+	}
+	c.JSON(200, _type.SuccessResult(mirrors))
+}
+
+func AddPushMirrorPost(c *context.Context, f form.PushMirror) {
+	mirror, err := AddPushMirror(c.Repo.RepoLink, AddPushMirrorOptions{
+		Address:    f.Address,
+		Username:   f.AuthUsername,
+		Password:   f.AuthPassword,
+		Interval:   f.Interval,
+		SyncOnPush: f.SyncOnPush,
+	})
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(newPushMirrorInfo(c.Repo.RepoLink, mirror)))
+}
+
+func DeletePushMirrorPost(c *context.Context) {
+	if err := DeletePushMirror(c.Repo.RepoLink, c.Params(":id")); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult("success"))
+}
+
+func SyncPushMirrorPost(c *context.Context) {
+	repoLink, id := c.Repo.RepoLink, c.Params(":id")
+	go func() {
+		if err := SyncPushMirror(repoLink, id); err != nil {
+			log.Error("Failed to sync push mirror [repo: %s, id: %s]: %v", repoLink, id, err)
+		}
+	}()
+	c.JSON(200, _type.SuccessResult("success"))
+}
+
+// HookPostReceive is called back by the post-receive hook of the repository
+// after each push, to run tasks that need to happen after pushes.
+func HookPostReceive(c *context.Context) {
+	repoLink := repoutil.FullRepoName(c.Params(":username"), strings.TrimSuffix(c.Params(":reponame"), ".git"))
+	if !hmac.Equal([]byte(c.Query("secret")), []byte(HookSecret(repoLink))) {
+		c.JSON(403, _type.FaildResult(errors.New("invalid secret")))
+		return
+	}
+
+	InvalidateRepoSize(repoLink)
+	if err := recordPush(repoLink); err != nil {
+		log.Error("Failed to record push [repo: %s]: %v", repoLink, err)
+	}
+	go AddIndexing(repoLink)
+	go syncPushMirrors(repoLink, func(m *PushMirror) bool {
+		return m.SyncOnPush
+	})
+	c.JSON(200, _type.SuccessResult("success"))
+}
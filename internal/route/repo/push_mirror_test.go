@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git-server/internal/conf"
+)
+
+func TestSyncPushMirror(t *testing.T) {
+	setupTestRoot(t)
+	conf.Repository.EnableLocalPathMigration = true
+	newTestRepo(t, "alice/repo")
+	require.NoError(t, initRepo("backup/repo"))
+
+	mirror, err := AddPushMirror("alice/repo", AddPushMirrorOptions{
+		Address:    repoPath("backup/repo"),
+		SyncOnPush: true,
+	})
+	require.NoError(t, err)
+
+	// Deleted branches kept for restoration are not mirrored.
+	runGit(t, repoPath("alice/repo"), "branch", "stale", "master")
+	runGit(t, repoPath("alice/repo"), "update-ref", refsDeleted+"1", "master")
+	require.NoError(t, SyncPushMirror("alice/repo", mirror.ID))
+	assert.Contains(t, runGit(t, repoPath("backup/repo"), "branch"), "master")
+	assert.Contains(t, runGit(t, repoPath("backup/repo"), "branch"), "stale")
+	assert.NotContains(t, runGit(t, repoPath("backup/repo"), "for-each-ref"), refsDeleted)
+
+	// Branches deleted from the repository are pruned from the mirror.
+	runGit(t, repoPath("alice/repo"), "branch", "-D", "stale")
+	require.NoError(t, SyncPushMirror("alice/repo", mirror.ID))
+	assert.NotContains(t, runGit(t, repoPath("backup/repo"), "branch"), "stale")
+
+	meta, err := GetRepoMeta("alice/repo")
+	require.NoError(t, err)
+	require.Len(t, meta.PushMirrors, 1)
+	assert.Empty(t, meta.PushMirrors[0].LastError)
+	assert.False(t, meta.PushMirrors[0].LastSync.IsZero())
+
+	require.NoError(t, DeletePushMirror("alice/repo", mirror.ID))
+	assert.Error(t, DeletePushMirror("alice/repo", mirror.ID))
+	assert.Error(t, SyncPushMirror("alice/repo", mirror.ID))
+}
+
+func TestPushMirror_Password(t *testing.T) {
+	setupTestRoot(t)
+	oldSecurity := conf.Security
+	conf.Security.SecretKey = "test-secret"
+	defer func() { conf.Security = oldSecurity }()
+	newTestRepo(t, "alice/repo")
+
+	mirror, err := AddPushMirror("alice/repo", AddPushMirrorOptions{
+		Address:  "https://example.com/backup/repo.git",
+		Username: "user",
+		Password: "p@ssword",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/backup/repo.git", mirror.Address)
+	assert.NotContains(t, mirror.EncryptedPassword, "p@ssword")
+
+	password, err := decryptPassword(mirror.EncryptedPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "p@ssword", password)
+
+	conf.Security.SecretKey = "another-secret"
+	_, err = decryptPassword(mirror.EncryptedPassword)
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package repo
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogs/git-module"
+	"github.com/pkg/errors"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/repoutil"
+	"git-server/internal/type"
+)
+
+const mebibyte = 1024 * 1024
+
+type repoSize struct {
+	size    int64
+	expires time.Time
+}
+
+// repoSizeCache caches disk usage of repositories by repository link.
+var repoSizeCache = struct {
+	sync.Mutex
+	sizes map[string]repoSize
+}{sizes: make(map[string]repoSize)}
+
+// RepoSize returns disk usage in bytes of the repository, the result is cached
+// for the duration of the configuration.
+func RepoSize(repoLink string) (int64, error) {
+	repoSizeCache.Lock()
+	cached, ok := repoSizeCache.sizes[repoLink]
+	repoSizeCache.Unlock()
+	if ok && cached.expires.After(time.Now()) {
+		return cached.size, nil
+	}
+
+	countObject, err := git.CountObjects(repoPath(repoLink))
+	if err != nil {
+		return 0, fmt.Errorf("count objects: %v", err)
+	}
+	size := countObject.Size + countObject.SizePack
+
+	repoSizeCache.Lock()
+	repoSizeCache.sizes[repoLink] = repoSize{
+		size:    size,
+		expires: time.Now().Add(time.Duration(conf.Quota.CacheTTL) * time.Second),
+	}
+	repoSizeCache.Unlock()
+	return size, nil
+}
+
+// InvalidateRepoSize drops the cached disk usage of the repository, it should
+// be called after the content of the repository is changed.
+func InvalidateRepoSize(repoLink string) {
+	repoSizeCache.Lock()
+	delete(repoSizeCache.sizes, repoLink)
+	repoSizeCache.Unlock()
+}
+
+// OwnerSize returns disk usage in bytes of all repositories of the owner.
+func OwnerSize(owner string) (int64, error) {
+	repos, err := GetRepos(owner)
+	if err != nil {
+		return 0, fmt.Errorf("get repositories: %v", err)
+	}
+
+	var total int64
+	for _, r := range repos {
+		size, err := RepoSize(strings.TrimSuffix(r, ".git"))
+		if err != nil {
+			return 0, fmt.Errorf("get size of %q: %v", r, err)
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// OwnerLimit returns the max disk usage in bytes of the owner, zero means unlimited.
+func OwnerLimit(owner string) int64 {
+	if limit, ok := conf.Quota.Owners[owner]; ok {
+		return limit * mebibyte
+	}
+	return conf.Quota.OwnerLimit * mebibyte
+}
+
+// RepoLimit returns the max disk usage in bytes of a repository, zero means unlimited.
+func RepoLimit() int64 {
+	return conf.Quota.RepoLimit * mebibyte
+}
+
+// ErrQuotaExceeded is returned when the disk usage would exceed the quota.
+type ErrQuotaExceeded struct {
+	Target string
+	Size   int64
+	Limit  int64
+}
+
+func IsErrQuotaExceeded(err error) bool {
+	_, ok := err.(ErrQuotaExceeded)
+	return ok
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("quota of %s exceeded: %.2f MiB of %.2f MiB",
+		err.Target, float64(err.Size)/mebibyte, float64(err.Limit)/mebibyte)
+}
+
+// CheckQuota returns ErrQuotaExceeded if the repository or its owner would exceed
+// the quota after adding given bytes to the repository.
+func CheckQuota(repoLink string, incoming int64) error {
+	if limit := RepoLimit(); limit > 0 {
+		size, err := RepoSize(repoLink)
+		if err != nil {
+			return err
+		}
+		if size+incoming > limit {
+			return ErrQuotaExceeded{Target: "repository " + repoLink, Size: size + incoming, Limit: limit}
+		}
+	}
+
+	owner := strings.SplitN(repoLink, "/", 2)[0]
+	if limit := OwnerLimit(owner); limit > 0 {
+		size, err := OwnerSize(owner)
+		if err != nil {
+			return err
+		}
+		if size+incoming > limit {
+			return ErrQuotaExceeded{Target: "owner " + owner, Size: size + incoming, Limit: limit}
+		}
+	}
+	return nil
+}
+
+type repoUsage struct {
+	Name string
+	Size int64
+}
+
+type quotaUsage struct {
+	Owner     string
+	Size      int64
+	Limit     int64 // Zero means unlimited
+	RepoLimit int64 // Zero means unlimited
+	Repos     []repoUsage
+}
+
+// QuotaUsage shows disk usage and quota of the owner and its repositories.
+func QuotaUsage(c *context.Context) {
+	owner := c.Query("code")
+	if owner == "" {
+		c.JSON(500, _type.FaildResult(errors.New("owner is required")))
+		return
+	}
+	repos, err := GetRepos(owner)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+
+	usage := quotaUsage{
+		Owner:     owner,
+		Limit:     OwnerLimit(owner),
+		RepoLimit: RepoLimit(),
+		Repos:     make([]repoUsage, 0, len(repos)),
+	}
+	for _, r := range repos {
+		size, err := RepoSize(strings.TrimSuffix(r, ".git"))
+		if err != nil {
+			c.JSON(500, _type.FaildResult(err))
+			return
+		}
+		usage.Size += size
+		usage.Repos = append(usage.Repos, repoUsage{
+			Name: strings.TrimSuffix(r, ".git"),
+			Size: size,
+		})
+	}
+	c.JSON(200, _type.SuccessResult(usage))
+}
+
+// HookPreReceive is called back by the pre-receive hook of the repository with
+// the size of incoming objects, to reject the push if it exceeds the quota.
+func HookPreReceive(c *context.Context) {
+	repoLink := repoutil.FullRepoName(c.Params(":username"), strings.TrimSuffix(c.Params(":reponame"), ".git"))
+	if !hmac.Equal([]byte(c.Query("secret")), []byte(HookSecret(repoLink))) {
+		c.JSON(403, _type.FaildResult(errors.New("invalid secret")))
+		return
+	}
+
+	incoming, _ := strconv.ParseInt(c.Query("size"), 10, 64)
+	if err := CheckQuota(repoLink, incoming); err != nil {
+		if IsErrQuotaExceeded(err) {
+			c.JSON(403, _type.FaildResult(err))
+			return
+		}
+		log.Error("Failed to check quota [repo: %s]: %v", repoLink, err)
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult("success"))
+}
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+)
+
+func TestCheckQuota(t *testing.T) {
+	setupTestRoot(t)
+	oldQuota := conf.Quota
+	defer func() { conf.Quota = oldQuota }()
+	conf.Quota = conf.QuotaOpts{CacheTTL: 60}
+
+	newTestRepo(t, "alice/repo")
+	newTestRepo(t, "alice/other")
+	size, err := RepoSize("alice/repo")
+	require.NoError(t, err)
+	require.Greater(t, size, int64(0))
+
+	// Unlimited by default.
+	assert.NoError(t, CheckQuota("alice/repo", 100*mebibyte))
+
+	conf.Quota.RepoLimit = 1
+	assert.NoError(t, CheckQuota("alice/repo", 0))
+	err = CheckQuota("alice/repo", mebibyte)
+	assert.True(t, IsErrQuotaExceeded(err))
+	assert.Contains(t, err.Error(), "repository alice/repo")
+
+	conf.Quota.RepoLimit = 0
+	conf.Quota.OwnerLimit = 1
+	conf.Quota.Owners = map[string]int64{"alice": 2}
+	ownerSize, err := OwnerSize("alice")
+	require.NoError(t, err)
+	assert.Greater(t, ownerSize, size)
+	assert.NoError(t, CheckQuota("alice/repo", mebibyte))
+	err = CheckQuota("alice/repo", 2*mebibyte)
+	assert.True(t, IsErrQuotaExceeded(err))
+	assert.Contains(t, err.Error(), "owner alice")
+
+	// Sizes are cached until being invalidated.
+	runGit(t, repoPath("alice/repo"), "repack", "-a", "-d")
+	cached, err := RepoSize("alice/repo")
+	require.NoError(t, err)
+	assert.Equal(t, size, cached)
+	InvalidateRepoSize("alice/repo")
+	size, err = RepoSize("alice/repo")
+	require.NoError(t, err)
+	assert.NotEqual(t, cached, size)
+}
+
+func TestUploadFileToServer_QuotaExceeded(t *testing.T) {
+	setupTestRoot(t)
+	oldQuota := conf.Quota
+	defer func() { conf.Quota = oldQuota }()
+	conf.Quota = conf.QuotaOpts{RepoLimit: 1}
+	newTestRepo(t, "alice/repo")
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	m.Post("/upload-file", func(c *context.Context) {
+		c.Repo.RepoLink = "alice/repo"
+	}, UploadFileToServer)
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "large.bin")
+	require.NoError(t, err)
+	_, err = part.Write(make([]byte, 2*mebibyte))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	req, err := http.NewRequest("POST", "/upload-file", &body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Contains(t, resp.Body.String(), "quota")
+}
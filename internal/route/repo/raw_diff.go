@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"git-server/internal/context"
+	"git-server/internal/form"
+	"git-server/internal/type"
+)
+
+// RawCommitDiff downloads the untruncated diff of the commit against its first
+// parent if the extension is "diff", or the commit in the format of
+// "git format-patch" which can be applied by "git am" if it is "patch".
+func RawCommitDiff(c *context.Context) {
+	from, to, err := commitDiffRange(c)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+
+	var args []string
+	switch format := c.Params(":ext"); format {
+	case "diff":
+		args, _ = rawDiffArgs(format, from, to)
+	case "patch":
+		args = []string{"format-patch", "--stdout", "--binary", "--full-index", "-1", to}
+	default:
+		c.JSON(500, _type.FaildResult(errors.Errorf("invalid format %q", format)))
+		return
+	}
+	serveGitOutput(c, c.Repo.GitRepo.Path(), to+"."+c.Params(":ext"), args...)
+}
+
+// RawPullDiff downloads the untruncated diff of the pull request if the
+// extension is "diff", or its commits in the format of "git format-patch" if
+// it is "patch".
+func RawPullDiff(c *context.Context, f form.PullRequest) {
+	if !checkPullRequestRepos(c, &f) {
+		return
+	}
+
+	gitRepo, startCommitID, endCommitID, _, err := pullDiffRange(c, f)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	format := c.Params(":ext")
+	args, err := rawDiffArgs(format, startCommitID, endCommitID)
+	if err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+	serveGitOutput(c, gitRepo.Path(), fmt.Sprintf("%d.%s", f.IssueId, format), args...)
+}
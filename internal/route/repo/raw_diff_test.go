@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-macaron/binding"
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/context"
+	"git-server/internal/form"
+)
+
+func TestRawDiff(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	repoPath := repoPath("alice/repo")
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath, ".")
+	runGit(t, workDir, "checkout", "-b", "feature")
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "feature.txt"), []byte("feature\n"), 0644))
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add feature")
+	runGit(t, workDir, "push", "origin", "feature")
+	rootID := strings.TrimSpace(runGit(t, repoPath, "rev-parse", "master"))
+	featureID := strings.TrimSpace(runGit(t, repoPath, "rev-parse", "feature"))
+
+	gitRepo, err := git.Open(repoPath)
+	require.NoError(t, err)
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Use(context.Contexter())
+	setRepo := func(c *context.Context) {
+		c.Repo.RepoLink, c.Repo.GitRepo = "alice/repo", gitRepo
+	}
+	m.Get("/commit/:sha([a-f0-9]{7,40})\\.:ext(diff|patch)$", setRepo, RawCommitDiff)
+	m.Post("/pulls/files\\.:ext(diff|patch)$", setRepo, binding.BindIgnErr(form.PullRequest{}), RawPullDiff)
+
+	get := func(t *testing.T, method, url string, body []byte) string {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		return resp.Body.String()
+	}
+
+	t.Run("root commit diff", func(t *testing.T) {
+		body := get(t, "GET", "/commit/"+rootID+".diff", nil)
+		assert.Contains(t, body, "+++ b/README.md")
+		assert.Contains(t, body, "+# test")
+	})
+
+	t.Run("commit patch", func(t *testing.T) {
+		body := get(t, "GET", "/commit/"+featureID[:7]+".patch", nil)
+		assert.Contains(t, body, "Subject: [PATCH] add feature")
+
+		// The patch applies to the parent with "git am".
+		amDir := t.TempDir()
+		runGit(t, amDir, "clone", "--branch", "master", repoPath, ".")
+		cmd := exec.Command("git", "am")
+		cmd.Dir = amDir
+		cmd.Stdin = strings.NewReader(body)
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, "%s", out)
+		assert.FileExists(t, filepath.Join(amDir, "feature.txt"))
+	})
+
+	t.Run("pull request diff", func(t *testing.T) {
+		pr, err := json.Marshal(form.PullRequest{
+			IssueId:    1,
+			BaseRepo:   "alice/repo",
+			BaseBranch: "master",
+			HeadRepo:   "alice/repo",
+			HeadBranch: "feature",
+		})
+		require.NoError(t, err)
+		body := get(t, "POST", "/pulls/files.diff", pr)
+		assert.Contains(t, body, "+++ b/feature.txt")
+		assert.NotContains(t, body, "README.md")
+	})
+
+	t.Run("inaccessible head repository", func(t *testing.T) {
+		newTestRepo(t, "bob/secret")
+		for headRepo, status := range map[string]int{
+			"bob/secret":      http.StatusUnauthorized,
+			"alice/../../bob": http.StatusBadRequest,
+		} {
+			pr, err := json.Marshal(form.PullRequest{
+				BaseRepo:   "alice/repo",
+				BaseBranch: "master",
+				HeadRepo:   headRepo,
+				HeadBranch: "master",
+			})
+			require.NoError(t, err)
+			req, err := http.NewRequest("POST", "/pulls/files.diff", bytes.NewReader(pr))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, status, resp.Code, headRepo)
+			assert.NotContains(t, resp.Body.String(), "README.md")
+		}
+	})
+
+	t.Run("git failure", func(t *testing.T) {
+		m.Get("/output/:rev", func(c *context.Context) {
+			serveGitOutput(c, repoPath, "output.diff", "diff", rootID, c.Params(":rev"))
+		})
+
+		// Failures before any output are responded as errors.
+		req, err := http.NewRequest("GET", "/output/missing", nil)
+		require.NoError(t, err)
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		assert.Empty(t, resp.Header().Get("Content-Disposition"))
+
+		// Empty output is not a failure.
+		req, err = http.NewRequest("GET", "/output/"+rootID, nil)
+		require.NoError(t, err)
+		resp = httptest.NewRecorder()
+		m.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Body.String())
+		assert.Contains(t, resp.Header().Get("Content-Disposition"), "output.diff")
+	})
+}
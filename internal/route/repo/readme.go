@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"path"
+	"strings"
+
+	"github.com/gogs/git-module"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/form"
+	"git-server/internal/markup"
+	"git-server/internal/tool"
+	"git-server/internal/type"
+)
+
+// Readme is the README file of a directory rendered to HTML.
+type Readme struct {
+	Name       string
+	Path       string
+	Size       int64
+	IsMarkdown bool
+	// Sanitized HTML, empty if the file is too large or not a text file.
+	HTML string
+}
+
+// findReadme returns the preferred README file among entries of a directory,
+// which are matched case-insensitively.
+func findReadme(entries git.Entries) *git.TreeEntry {
+	var (
+		found *git.TreeEntry
+		rank  = len(markup.ReadmeNames)
+	)
+	for _, entry := range entries {
+		if !entry.IsBlob() || entry.IsSymlink() {
+			continue
+		}
+		for i, name := range markup.ReadmeNames {
+			if i < rank && strings.EqualFold(entry.Name(), name) {
+				found, rank = entry, i
+				break
+			}
+		}
+	}
+	return found
+}
+
+// GetReadme returns the README file of the directory with its entries, or nil
+// if there is none. Relative links are resolved against rawLink, the URL of
+// raw files at the reference.
+func GetReadme(entries git.Entries, dir, rawLink string) (*Readme, error) {
+	entry := findReadme(entries)
+	if entry == nil {
+		return nil, nil
+	}
+	readme := &Readme{
+		Name:       entry.Name(),
+		Path:       path.Join(dir, entry.Name()),
+		Size:       entry.Size(),
+		IsMarkdown: markup.IsMarkdownFile(entry.Name()),
+	}
+	if conf.View.MaxReadmeSize > 0 && readme.Size > conf.View.MaxReadmeSize*1024 {
+		return readme, nil
+	}
+
+	data, err := entry.Blob().Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if !tool.IsTextFile(data) {
+		return readme, nil
+	}
+	if readme.IsMarkdown {
+		readme.HTML = string(markup.Markdown(data, rawLink, dir))
+	} else {
+		readme.HTML = string(markup.PlainText(data))
+	}
+	return readme, nil
+}
+
+// RenderMarkdown renders markdown of the document in the path to sanitized
+// HTML, with relative links resolved against raw files at the commit of the
+// reference.
+func RenderMarkdown(c *context.Context, f form.Markdown) {
+	rawLink := rawFileURL(c.Repo.GitRepo.Path(), c.Repo.CommitID, "")
+	c.JSON(200, _type.SuccessResult(string(markup.Markdown([]byte(f.Text), rawLink, path.Dir(c.Repo.TreePath)))))
+}
@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogs/git-module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git-server/internal/conf"
+)
+
+func TestGetReadme(t *testing.T) {
+	setupTestRoot(t)
+	newTestRepo(t, "alice/repo")
+	before := conf.View
+	t.Cleanup(func() {
+		conf.View = before
+	})
+	conf.View.MaxReadmeSize = 1
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", repoPath("alice/repo"), ".")
+	for name, content := range map[string]string{
+		"README.md":        "# test\n\n![logo](logo.png)\n",
+		"docs/README":      "plain",
+		"docs/readme.txt":  "a <b>\n",
+		"empty/.gitkeep":   "",
+		"large/README.md":  string(make([]byte, 2048)),
+		"nested/README.md": "[up](../README.md)\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(workDir, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(workDir, name), []byte(content), 0644))
+	}
+	runGit(t, workDir, "add", "--all")
+	runGit(t, workDir, "commit", "-m", "add readmes")
+	runGit(t, workDir, "push", "origin", "master")
+
+	gitRepo, err := git.Open(repoPath("alice/repo"))
+	require.NoError(t, err)
+	commit, err := gitRepo.BranchCommit("master")
+	require.NoError(t, err)
+	const rawLink = "http://localhost:4000/alice/repo/raw/master"
+	readme := func(dir string) *Readme {
+		tree, err := commit.Subtree(dir)
+		require.NoError(t, err)
+		entries, err := tree.Entries()
+		require.NoError(t, err)
+		readme, err := GetReadme(entries, dir, rawLink)
+		require.NoError(t, err)
+		return readme
+	}
+
+	r := readme("")
+	require.NotNil(t, r)
+	assert.Equal(t, "README.md", r.Path)
+	assert.True(t, r.IsMarkdown)
+	assert.Contains(t, r.HTML, `<h1 id="test">test</h1>`)
+	assert.Contains(t, r.HTML, `src="`+rawLink+`/logo.png"`)
+
+	r = readme("docs")
+	require.NotNil(t, r)
+	assert.Equal(t, "docs/readme.txt", r.Path)
+	assert.False(t, r.IsMarkdown)
+	assert.Equal(t, "<pre>a &lt;b&gt;\n</pre>", r.HTML)
+
+	r = readme("nested")
+	require.NotNil(t, r)
+	assert.Contains(t, r.HTML, `href="`+rawLink+`/README.md"`)
+
+	r = readme("large")
+	require.NotNil(t, r)
+	assert.Equal(t, int64(2048), r.Size)
+	assert.Empty(t, r.HTML)
+
+	assert.Nil(t, readme("empty"))
+}
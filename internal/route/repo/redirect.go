@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+	"git-server/internal/form"
+	"git-server/internal/osutil"
+	"git-server/internal/repoutil"
+)
+
+// RepoRedirect records the new location of a renamed or transferred repository.
+// It is kept after expiration to resolve references of pull requests to the
+// repository, while URLs are only redirected before it expires.
+type RepoRedirect struct {
+	NewLink string
+	Expires time.Time
+}
+
+// redirectsPath returns the path of the file that persists repository redirects.
+func redirectsPath() string {
+	return filepath.Join(conf.Repository.Root, "redirects.json")
+}
+
+func readRedirects(p string) (map[string]*RepoRedirect, error) {
+	redirects := make(map[string]*RepoRedirect)
+	if !osutil.IsFile(p) {
+		return redirects, nil
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %v", err)
+	}
+	if err = json.Unmarshal(data, &redirects); err != nil {
+		return nil, fmt.Errorf("unmarshal: %v", err)
+	}
+	return redirects, nil
+}
+
+// addRepoRedirect redirects the old repository link to the new one, where URLs
+// are redirected for the grace period of the configuration. Existing redirects to
+// the old repository link are pointed to the new one as well.
+func addRepoRedirect(oldLink, newLink string) error {
+	p := redirectsPath()
+	repoWorkingPool.CheckIn(p)
+	defer repoWorkingPool.CheckOut(p)
+
+	redirects, err := readRedirects(p)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range redirects {
+		if r.NewLink == oldLink {
+			r.NewLink = newLink
+		}
+	}
+	// The new repository link is in use again.
+	delete(redirects, newLink)
+	redirects[oldLink] = &RepoRedirect{
+		NewLink: newLink,
+		Expires: time.Now().AddDate(0, 0, conf.Repository.RedirectGracePeriod),
+	}
+
+	data, err := json.MarshalIndent(redirects, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+	if err = os.WriteFile(p, data, 0644); err != nil {
+		return fmt.Errorf("write file: %v", err)
+	}
+	return nil
+}
+
+// getRepoRedirect returns the redirect of the repository link, expired or not.
+func getRepoRedirect(repoLink string) (*RepoRedirect, bool) {
+	p := redirectsPath()
+	repoWorkingPool.CheckIn(p)
+	defer repoWorkingPool.CheckOut(p)
+
+	redirects, err := readRedirects(p)
+	if err != nil {
+		return nil, false
+	}
+	r, ok := redirects[repoLink]
+	return r, ok
+}
+
+// LookupRepoRedirect returns the new repository link that the given one has been
+// renamed or transferred to, if it is still in the grace period.
+func LookupRepoRedirect(repoLink string) (string, bool) {
+	r, ok := getRepoRedirect(repoLink)
+	if !ok || r.Expires.Before(time.Now()) {
+		return "", false
+	}
+	return r.NewLink, true
+}
+
+// ResolveRepoLink returns the current link of the repository, following the
+// redirect if the repository has been renamed or transferred, regardless of
+// the grace period.
+func ResolveRepoLink(repoLink string) string {
+	if repoLink == "" || repoExists(repoPath(repoLink)) {
+		return repoLink
+	}
+	if r, ok := getRepoRedirect(repoLink); ok {
+		return r.NewLink
+	}
+	return repoLink
+}
+
+// resolvePullRequestRepos rewrites repositories referenced by the pull request
+// to their current links.
+func resolvePullRequestRepos(f *form.PullRequest) {
+	f.HeadRepo = ResolveRepoLink(f.HeadRepo)
+	f.BaseRepo = ResolveRepoLink(f.BaseRepo)
+}
+
+// RedirectRenamed redirects requests to the old URL of a renamed or transferred
+// repository to its new location.
+func RedirectRenamed(c *macaron.Context) {
+	ownerName, rawName := c.Params(":username"), c.Params(":reponame")
+	repoName := strings.TrimSuffix(rawName, ".git")
+	repoLink := repoutil.FullRepoName(ownerName, repoName)
+	if repoExists(repoPath(repoLink)) {
+		return
+	}
+	newLink, ok := LookupRepoRedirect(repoLink)
+	if !ok {
+		return
+	}
+
+	newPath := "/" + newLink + strings.TrimPrefix(rawName, repoName) +
+		strings.TrimPrefix(c.Req.URL.Path, "/"+ownerName+"/"+rawName)
+	if c.Req.URL.RawQuery != "" {
+		newPath += "?" + c.Req.URL.RawQuery
+	}
+
+	// Preserve the method and body for requests other than reads.
+	status := 301
+	if c.Req.Method != "GET" && c.Req.Method != "HEAD" {
+		status = 307
+	}
+	c.Redirect(newPath, status)
+}
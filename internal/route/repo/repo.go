@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+	log "unknwon.dev/clog/v2"
 )
 
 type NewBranchOpts struct {
@@ -29,13 +30,31 @@ type Branch struct {
 
 	IsProtected bool
 	Commit      *git.Commit
+	// How the branch relates to the base branch, nil if not compared.
+	Divergence *BranchDivergence `json:",omitempty"`
 }
 
 func CreatePost(c *context.Context, f form.Repo) {
+	// The whole tree of the template repository is copied to the new one.
+	if f.TemplateRepo != "" && !canReadRepo(c, strings.TrimSuffix(f.TemplateRepo, ".git")) {
+		return
+	}
+
 	repoPath := repoutil.RepoPath(f.Code, f.RepoName)
-	FullRepoName := repoutil.FullRepoName(f.Code, f.RepoName)
 	if !repoExists(repoPath) {
-		err := initRepo(FullRepoName)
+		err := CreateRepository(CreateRepoOptions{
+			Owner:         f.Code,
+			Name:          f.RepoName,
+			Description:   f.Description,
+			Website:       f.Website,
+			IsPublic:      f.Private != nil && !*f.Private,
+			DefaultBranch: f.DefaultBranch,
+			AutoInit:      f.AutoInit,
+			Readme:        f.Readme,
+			Gitignores:    f.Gitignores,
+			License:       f.License,
+			TemplateRepo:  f.TemplateRepo,
+		})
 		if err != nil {
 			result := _type.FaildResult(err)
 			c.JSON(500, result)
@@ -52,17 +71,18 @@ func CreatePost(c *context.Context, f form.Repo) {
 	result := _type.FaildResult(err)
 	c.JSON(500, result)
 }
-func DeletePost(c *context.Context, form form.Repo) {
-	fullPath := repoutil.RepoPath(form.Code, form.RepoName)
-	f, err := os.Lstat(fullPath)
-	if err != nil || f == nil {
-		c.JSON(500, _type.FaildResult(errors.New("repoPath is not exist")))
-		return
-	}
 
-	err = os.RemoveAll(fullPath)
-	if err != nil || f == nil {
-		c.JSON(500, _type.FaildResult(errors.New("delete repo err")))
+// CreateOptions returns names of files that can be used to initialize a repository.
+func CreateOptions(c *context.Context) {
+	c.JSON(200, _type.SuccessResult(map[string][]string{
+		"Readmes":    conf.AssetNames(conf.AssetReadme),
+		"Gitignores": conf.AssetNames(conf.AssetGitignore),
+		"Licenses":   conf.AssetNames(conf.AssetLicense),
+	}))
+}
+func DeletePost(c *context.Context, form form.Repo) {
+	if _, err := SoftDeleteRepository(repoutil.FullRepoName(form.Code, form.RepoName)); err != nil {
+		c.JSON(500, _type.FaildResult(err))
 		return
 	}
 	c.JSON(200, _type.SuccessResult("success"))
@@ -84,18 +104,11 @@ func initRepo(FullRepoName string) error {
 }
 
 func DeleteRepo(c *context.Context, f form.Repo) {
-	repoPath := repoutil.RepoPath(f.Code, f.RepoName)
-	file, err := os.Lstat(repoPath)
-	if err != nil || file == nil {
-		c.JSON(500, _type.FaildResult(err))
-		return
-	}
-	err = os.RemoveAll(repoPath)
-	if err != nil || file == nil {
+	repoName := repoutil.FullRepoName(f.Code, f.RepoName)
+	if _, err := SoftDeleteRepository(repoName); err != nil {
 		c.JSON(500, _type.FaildResult(err))
 		return
 	}
-	repoName := repoutil.FullRepoName(f.Code, f.RepoName)
 	c.JSON(200, _type.SuccessResult(repoName))
 }
 
@@ -125,11 +138,89 @@ func ListRepo(c *context.Context, f form.ListRepo) {
 	if err != nil {
 		result := _type.FaildResult(err)
 		c.JSON(500, result)
+		return
 	}
-	result := _type.SuccessResult(repos)
+	infos := make([]*RepoInfo, 0, len(repos))
+	for _, r := range repos {
+		info, err := GetRepoInfo(strings.TrimSuffix(r, ".git"))
+		if err != nil {
+			c.JSON(500, _type.FaildResult(err))
+			return
+		}
+		infos = append(infos, info)
+	}
+	result := _type.SuccessResult(infos)
 	c.JSON(200, result)
 }
 
+// RepoInfo contains metadata and disk usage of a repository.
+type RepoInfo struct {
+	Owner         string
+	Name          string
+	FullName      string
+	Description   string
+	Website       string
+	IsPrivate     bool
+	IsArchived    bool
+	IsFork        bool
+	ForkFrom      string
+	IsMirror      bool
+	DefaultBranch string
+	Created       time.Time
+	Size          int64 // In bytes
+	CloneLink     repoutil.CloneLink
+}
+
+// GetRepoInfo returns information of the repository by given repository link.
+func GetRepoInfo(repoLink string) (*RepoInfo, error) {
+	repoPath := repoPath(repoLink)
+	meta, err := GetRepoMeta(repoLink)
+	if err != nil {
+		return nil, fmt.Errorf("get repository meta: %v", err)
+	}
+
+	created := meta.Created
+	if created.IsZero() {
+		// Repositories created before metadata was recorded.
+		fi, err := os.Stat(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		created = fi.ModTime()
+	}
+
+	defaultBranch, err := git.SymbolicRef(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("get default branch: %v", err)
+	}
+
+	countObject, err := git.CountObjects(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("count objects: %v", err)
+	}
+
+	owner, name := path.Split(repoLink)
+	owner = strings.TrimSuffix(owner, "/")
+	return &RepoInfo{
+		Owner:         owner,
+		Name:          name,
+		FullName:      repoLink,
+		Description:   meta.Description,
+		Website:       meta.Website,
+		IsPrivate:     meta.IsPrivate,
+		IsArchived:    meta.IsArchived,
+		IsFork:        meta.IsFork(),
+		ForkFrom:      meta.ForkFrom,
+		IsMirror:      meta.IsMirror(),
+		DefaultBranch: strings.TrimPrefix(defaultBranch, git.RefsHeads),
+		Created:       created,
+		Size:          countObject.Size + countObject.SizePack,
+		CloneLink: repoutil.CloneLink{
+			HTTPS: repoutil.HTTPSCloneURL(owner, name),
+		},
+	}, nil
+}
+
 func getAllRepos() ([]string, error) {
 	fullPath := conf.Repository.Root
 	dirs, err := os.ReadDir(fullPath)
@@ -256,15 +347,57 @@ var hooksTpls = map[git.HookName]string{
 	"post-receive": "#!/usr/bin/env %s\n\"%s\" hook --config='%s' post-receive\n",
 }
 
+// delegateHooks contains hooks that are delegated to the "hook" command.
+var delegateHooks = []git.HookName{git.HookPreReceive, git.HookPostReceive}
+
 func createDelegateHooks(repoPath string) (err error) {
-	for _, name := range git.ServerSideHooks {
-		hookPath := filepath.Join(repoPath, "hooks", string(name))
-		if err = os.WriteFile(hookPath,
-			[]byte(fmt.Sprintf(hooksTpls[name], conf.Repository.ScriptType, conf.Repository.BashPath, "")),
-			os.ModePerm); err != nil {
-			return fmt.Errorf("create delegate hook '%s': %v", hookPath, err)
+	for _, name := range delegateHooks {
+		if err = createDelegateHook(repoPath, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InitHooks creates or refreshes delegate hooks of all repositories, so those
+// created before a hook was added or with an outdated configuration path get
+// the same hooks as new ones.
+func InitHooks() {
+	repos, err := getAllRepos()
+	if err != nil {
+		log.Error("InitHooks: list repositories: %v", err)
+		return
+	}
+	for _, r := range repos {
+		if err = createDelegateHooks(filepath.Join(conf.Repository.Root, r)); err != nil {
+			log.Error("InitHooks [repo: %s]: %v", r, err)
+		}
+	}
+}
+
+func createDelegateHook(repoPath string, name git.HookName) error {
+	hookPath := filepath.Join(repoPath, "hooks", string(name))
+
+	// The pre-receive hook carries protected branches, the others carry
+	// the path of configuration file.
+	arg := conf.CustomConf
+	if name == git.HookPreReceive {
+		repoWorkingPool.CheckIn(hookPath)
+		defer repoWorkingPool.CheckOut(hookPath)
+
+		// Protected branches of an existing hook are kept when it is refreshed.
+		arg = ""
+		if content, err := os.ReadFile(hookPath); err == nil {
+			if m := protectedBranchesPattern.FindSubmatch(content); m != nil {
+				arg = string(m[1])
+			}
 		}
-		break
+	}
+
+	if err := os.WriteFile(hookPath,
+		[]byte(fmt.Sprintf(hooksTpls[name], conf.Repository.ScriptType, conf.Repository.BashPath, arg)),
+		os.ModePerm); err != nil {
+		return fmt.Errorf("create delegate hook '%s': %v", hookPath, err)
 	}
 	return nil
 }
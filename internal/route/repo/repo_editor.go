@@ -79,6 +79,12 @@ func UpdateLocalCopyBranch(repoLink, branch string) error {
 // assume subsequent operations are against target branch when caller has confidence
 // about no race condition.
 func updateLocalCopyBranch(repoPath, localPath, branch string, isWiki bool) (err error) {
+	defer func() {
+		if err == nil {
+			touchLocalCopy(localPath)
+		}
+	}()
+
 	if !osutil.IsExist(localPath) {
 		// Checkout to a specific branch fails when wiki is an empty repository.
 		if isWiki {
@@ -89,7 +89,27 @@ func SettingsProtectedBranch(c *context.Context) {
 }
 
 func GetProtectedBranch(c *context.Context) ([]string, error) {
-	repoPath := filepath.Join(conf.Repository.Root, c.Repo.RepoLink) + ".git"
+	branches, err := getProtectedBranches(c.Repo.RepoLink)
+	if err != nil {
+		return nil, err
+	}
+	protectedBranches := make([]string, 0)
+	for i := 0; i < len(branches); i++ {
+		if c.Repo.GitRepo.HasBranch(branches[i]) {
+			protectedBranches = append(protectedBranches, branches[i])
+		}
+	}
+	return protectedBranches, nil
+}
+
+// protectedBranchesPattern matches the comma-separated protected branches in
+// the pre-receive hook.
+var protectedBranchesPattern = regexp.MustCompile(`--branch='([^']*)'`)
+
+// getProtectedBranches returns names of protected branches of the repository,
+// which may include ones that no longer exist.
+func getProtectedBranches(repoLink string) ([]string, error) {
+	repoPath := filepath.Join(conf.Repository.Root, repoLink) + ".git"
 	filePath := filepath.Join(repoPath, "hooks", "pre-receive")
 
 	repoWorkingPool.CheckIn(com.ToStr(filePath))
@@ -101,19 +121,12 @@ func GetProtectedBranch(c *context.Context) ([]string, error) {
 		return nil, errors.New("File reading failure")
 	}
 	// 使用正则表达式匹配并替换
-	re := regexp.MustCompile(`--branch='([^']*)'`)
+	re := protectedBranchesPattern
 	matches := re.FindStringSubmatch(string(content))
 	if len(matches) != 2 {
 		return nil, errors.New("No match found")
 	}
-	branches := strings.Split(matches[1], ",")
-	protectedBranches := make([]string, 0)
-	for i := 0; i < len(branches); i++ {
-		if c.Repo.GitRepo.HasBranch(branches[i]) {
-			protectedBranches = append(protectedBranches, branches[i])
-		}
-	}
-	return protectedBranches, nil
+	return strings.Split(matches[1], ","), nil
 }
 
 func updateProtectedBranch(repoLink string, f form.ProtectedBranch) error {
@@ -137,20 +150,23 @@ func updateProtectedBranch(repoLink string, f form.ProtectedBranch) error {
 	}
 
 	// 使用正则表达式匹配并替换
-	re := regexp.MustCompile(`--branch='([^']*)'`)
+	re := protectedBranchesPattern
 	matches := re.FindStringSubmatch(string(content))
 	if len(matches) != 2 {
 		return errors.New("No match found")
 	}
 	oldValue := matches[1] // 旧值
-	var newValue string    // 新值
+	// Names are matched exactly so that other branches sharing a prefix are kept.
+	branches := make([]string, 0)
+	for _, b := range strings.Split(oldValue, ",") {
+		if b != "" && b != f.BranchName {
+			branches = append(branches, b)
+		}
+	}
 	if f.Protected {
-		newValue = oldValue + "," + f.BranchName
-	} else {
-		newValue = strings.ReplaceAll(oldValue, f.BranchName, "")
-		newValue = strings.ReplaceAll(newValue, ",,", ",")
+		branches = append(branches, f.BranchName)
 	}
-	newValue = strings.Trim(newValue, ",")
+	newValue := strings.Join(branches, ",") // 新值
 	newContent := re.ReplaceAllString(string(content), fmt.Sprintf(`--branch='%s'`, newValue))
 
 	// 将文件指针移至文件开始位置
@@ -197,12 +213,77 @@ func UpdateDefaultBranch(c *context.Context) {
 	branch := c.Query("branch")
 	if c.Repo.GitRepo.HasBranch(branch) &&
 		c.Repo.BranchName != branch {
-		if _, err := c.Repo.GitRepo.SymbolicRef(git.SymbolicRefOptions{
-			Ref: git.RefsHeads + branch,
-		}); err != nil {
+		if err := setDefaultBranch(c.Repo.GitRepo.Path(), branch); err != nil {
 			c.JSON(500, _type.FaildResult(err))
 			return
 		}
 	}
 	c.JSON(200, _type.SuccessResult("success"))
 }
+
+// setDefaultBranch points HEAD of the repository to the branch.
+func setDefaultBranch(repoPath, branch string) error {
+	_, err := git.SymbolicRef(repoPath, git.SymbolicRefOptions{
+		Ref: git.RefsHeads + branch,
+	})
+	return err
+}
+
+func Settings(c *context.Context) {
+	info, err := GetRepoInfo(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(info))
+}
+
+func SettingsPost(c *context.Context, f form.RepoSetting) {
+	if err := UpdateRepoMeta(c.Repo.RepoLink, func(meta *RepoMeta) {
+		if f.Description != nil {
+			meta.Description = *f.Description
+		}
+		if f.Website != nil {
+			meta.Website = *f.Website
+		}
+		if f.Private != nil {
+			meta.IsPrivate = *f.Private
+		}
+	}); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult("success"))
+}
+
+func setArchived(c *context.Context, archived bool) {
+	if err := UpdateRepoMeta(c.Repo.RepoLink, func(meta *RepoMeta) {
+		meta.IsArchived = archived
+	}); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult("success"))
+}
+
+func ArchivePost(c *context.Context) {
+	setArchived(c, true)
+}
+
+func UnarchivePost(c *context.Context) {
+	setArchived(c, false)
+}
+
+// MustBeNotArchived rejects the request if the repository is archived,
+// which is read-only until being unarchived.
+func MustBeNotArchived(c *context.Context) {
+	meta, err := GetRepoMeta(c.Repo.RepoLink)
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	if meta.IsArchived {
+		c.JSON(403, _type.FaildResult(errors.New("repository is archived")))
+		return
+	}
+}
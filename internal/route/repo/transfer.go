@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/context"
+	"git-server/internal/form"
+	"git-server/internal/osutil"
+	processed "git-server/internal/process"
+	"git-server/internal/repoutil"
+	"git-server/internal/type"
+)
+
+// TransferRepository moves the repository along with its local copy to the new
+// repository link, which renames the repository or transfers it to another owner.
+// Forks of the repository are updated to borrow objects from the new location,
+// and the old repository link keeps redirecting to the new one for a grace period.
+func TransferRepository(oldLink, newLink string) (err error) {
+	if oldLink == newLink {
+		return errors.Errorf("repository is already %q", newLink)
+	}
+	oldPath, newPath := repoPath(oldLink), repoPath(newLink)
+	if !repoExists(oldPath) {
+		return errors.Errorf("repository %q does not exist", oldLink)
+	} else if osutil.IsExist(newPath) {
+		return errors.Errorf("repository %q already exists", newLink)
+	}
+
+	forks, err := GetForks(oldLink)
+	if err != nil {
+		return fmt.Errorf("get forks: %v", err)
+	}
+
+	// Lock in a fixed order to not deadlock with a transfer in the opposite direction.
+	keys := []string{oldLink, newLink}
+	sort.Strings(keys)
+	for _, key := range keys {
+		repoWorkingPool.CheckIn(key)
+		defer repoWorkingPool.CheckOut(key)
+	}
+
+	if err = moveRepository(oldLink, newLink); err != nil {
+		return err
+	}
+
+	oldObjects, err := filepath.Abs(filepath.Join(oldPath, "objects"))
+	if err != nil {
+		return err
+	}
+	newObjects, err := filepath.Abs(filepath.Join(newPath, "objects"))
+	if err != nil {
+		return err
+	}
+	for _, fork := range forks {
+		alternates := filepath.Join(repoPath(fork), "objects", "info", "alternates")
+		data, err := os.ReadFile(alternates)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("read alternates of %q: %v", fork, err)
+		} else if err == nil {
+			data = []byte(strings.ReplaceAll(string(data), oldObjects, newObjects))
+			if err = os.WriteFile(alternates, data, 0644); err != nil {
+				return fmt.Errorf("write alternates of %q: %v", fork, err)
+			}
+		}
+
+		if err = UpdateRepoMeta(fork, func(meta *RepoMeta) {
+			meta.ForkFrom = newLink
+		}); err != nil {
+			return fmt.Errorf("update repository meta of %q: %v", fork, err)
+		}
+	}
+
+	InvalidateRepoSize(oldLink)
+	RemoveIndex(oldLink)
+	go AddIndexing(newLink)
+	if err = addRepoRedirect(oldLink, newLink); err != nil {
+		return fmt.Errorf("add redirect: %v", err)
+	}
+	return nil
+}
+
+// moveRepository renames the bare repository and its local copy, the bare
+// repository is moved back if the local copy fails to move.
+func moveRepository(oldLink, newLink string) (err error) {
+	oldPath, newPath := repoPath(oldLink), repoPath(newLink)
+
+	// Metadata must not be written to the old location during the move.
+	metaPath := RepoMetaPath(oldPath)
+	repoWorkingPool.CheckIn(metaPath)
+	defer repoWorkingPool.CheckOut(metaPath)
+
+	if err = os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+		return err
+	}
+	if err = os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("move repository: %v", err)
+	}
+
+	oldLocalPath, newLocalPath := LocalCopyPath(oldLink), LocalCopyPath(newLink)
+	if !osutil.IsExist(oldLocalPath) {
+		return nil
+	}
+	if err = os.MkdirAll(filepath.Dir(newLocalPath), os.ModePerm); err == nil {
+		err = os.Rename(oldLocalPath, newLocalPath)
+	}
+	if err != nil {
+		if rerr := os.Rename(newPath, oldPath); rerr != nil {
+			log.Error("Failed to move back repository %q: %v", oldLink, rerr)
+		}
+		return fmt.Errorf("move local copy: %v", err)
+	}
+
+	if _, stderr, err := processed.ExecDir(-1, newLocalPath,
+		fmt.Sprintf("moveRepository (git remote set-url): %s -> %s", oldLink, newLink),
+		"git", "remote", "set-url", "origin", newPath); err != nil {
+		// The local copy is recreated on demand, it is fine to drop it.
+		log.Error("Failed to update remote of local copy %q: %v - %s", newLocalPath, err, stderr)
+		_ = os.RemoveAll(newLocalPath)
+	}
+	return nil
+}
+
+// validateRepoName returns an error if the owner or name of a repository is
+// empty or could resolve to a path outside of the repository root.
+func validateRepoName(owner, name string) error {
+	if owner == "" || name == "" ||
+		strings.ContainsAny(owner+name, `/\`) || strings.HasPrefix(owner, ".") || strings.HasPrefix(name, ".") {
+		return errors.Errorf("invalid repository name %q", repoutil.FullRepoName(owner, name))
+	}
+	return nil
+}
+
+func TransferPost(c *context.Context, f form.TransferRepo) {
+	owner, name := c.Params(":username"), strings.TrimSuffix(c.Params(":reponame"), ".git")
+	if f.NewOwner != "" {
+		owner = f.NewOwner
+	}
+	if f.NewName != "" {
+		name = f.NewName
+	}
+	if err := validateRepoName(owner, name); err != nil {
+		c.JSON(400, _type.FaildResult(err))
+		return
+	}
+
+	if err := TransferRepository(c.Repo.RepoLink, repoutil.FullRepoName(owner, name)); err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(repoutil.CloneLink{
+		HTTPS: repoutil.HTTPSCloneURL(owner, name),
+	}))
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+
+	"git-server/internal/conf"
+)
+
+func TestTransferRepository(t *testing.T) {
+	setupTestRoot(t)
+	conf.Repository.RedirectGracePeriod = 1
+	newTestRepo(t, "alice/repo")
+	require.NoError(t, ForkRepository("alice/repo", "bob/repo"))
+	require.NoError(t, UpdateLocalCopyBranch("alice/repo", "master"))
+
+	require.NoError(t, TransferRepository("alice/repo", "carol/renamed"))
+	assert.False(t, repoExists(repoPath("alice/repo")))
+	assert.True(t, repoExists(repoPath("carol/renamed")))
+
+	// The local copy is moved and still tracks the bare repository.
+	assert.NoDirExists(t, LocalCopyPath("alice/repo"))
+	remote := runGit(t, LocalCopyPath("carol/renamed"), "remote", "get-url", "origin")
+	assert.Equal(t, repoPath("carol/renamed"), strings.TrimSpace(remote))
+	require.NoError(t, UpdateLocalCopyBranch("carol/renamed", "master"))
+
+	// The fork borrows objects from the new location.
+	alternates, err := os.ReadFile(filepath.Join(repoPath("bob/repo"), "objects", "info", "alternates"))
+	require.NoError(t, err)
+	assert.Contains(t, string(alternates), "carol")
+	runGit(t, repoPath("bob/repo"), "fsck")
+	meta, err := GetRepoMeta("bob/repo")
+	require.NoError(t, err)
+	assert.Equal(t, "carol/renamed", meta.ForkFrom)
+
+	assert.Equal(t, "carol/renamed", ResolveRepoLink("alice/repo"))
+	assert.Equal(t, "bob/repo", ResolveRepoLink("bob/repo"))
+
+	// Redirects follow subsequent transfers.
+	require.NoError(t, TransferRepository("carol/renamed", "dave/repo"))
+	assert.Equal(t, "dave/repo", ResolveRepoLink("alice/repo"))
+	assert.Equal(t, "dave/repo", ResolveRepoLink("carol/renamed"))
+
+	// References of pull requests are resolved after the grace period.
+	conf.Repository.RedirectGracePeriod = 0
+	require.NoError(t, TransferRepository("dave/repo", "erin/repo"))
+	_, ok := LookupRepoRedirect("dave/repo")
+	assert.False(t, ok)
+	assert.Equal(t, "erin/repo", ResolveRepoLink("alice/repo"))
+	assert.Equal(t, "erin/repo", ResolveRepoLink("dave/repo"))
+
+	assert.Error(t, TransferRepository("erin/repo", "bob/repo"), "already exists")
+	assert.Error(t, TransferRepository("alice/repo", "frank/repo"), "does not exist")
+}
+
+func TestRedirectRenamed(t *testing.T) {
+	setupTestRoot(t)
+	conf.Repository.RedirectGracePeriod = 1
+	newTestRepo(t, "alice/repo")
+	require.NoError(t, TransferRepository("alice/repo", "bob/repo"))
+
+	m := macaron.New()
+	m.Use(macaron.Renderer())
+	m.Route("/:username/:reponame/*", "GET,POST", RedirectRenamed, func(c *macaron.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		method       string
+		url          string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"GET", "/alice/repo.git/info/refs?service=git-upload-pack", http.StatusMovedPermanently, "/bob/repo.git/info/refs?service=git-upload-pack"},
+		{"GET", "/alice/repo/src/master", http.StatusMovedPermanently, "/bob/repo/src/master"},
+		{"POST", "/alice/repo.git/git-upload-pack", http.StatusTemporaryRedirect, "/bob/repo.git/git-upload-pack"},
+		{"GET", "/bob/repo/src/master", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.url, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, test.url, nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			m.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantStatus, resp.Code)
+			assert.Equal(t, test.wantLocation, resp.Header().Get("Location"))
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	gouuid "github.com/satori/go.uuid"
+	log "unknwon.dev/clog/v2"
+
+	"git-server/internal/conf"
+	"git-server/internal/context"
+	"git-server/internal/osutil"
+	processed "git-server/internal/process"
+	"git-server/internal/repoutil"
+	"git-server/internal/type"
+)
+
+// DeletedRepo contains information of a repository that has been moved to trash.
+type DeletedRepo struct {
+	ID       string
+	RepoLink string
+	Deleted  time.Time
+	// Zero time means the repository is kept until being purged manually.
+	Expires time.Time
+}
+
+const taskPurgeTrash = "purge_trash"
+
+// TrashPath returns the directory that deleted repositories are moved to.
+func TrashPath() string {
+	return filepath.Join(conf.Server.AppDataPath, "trash")
+}
+
+func trashEntryPath(id string) string {
+	return filepath.Join(TrashPath(), id)
+}
+
+func trashRepoPath(id string) string {
+	return filepath.Join(trashEntryPath(id), "repo.git")
+}
+
+func trashInfoPath(id string) string {
+	return filepath.Join(trashEntryPath(id), "deleted.json")
+}
+
+// dissociateRepository copies objects borrowed through alternates into the
+// repository itself, so it keeps working after the other repository is gone.
+func dissociateRepository(repoPath string) error {
+	alternates := filepath.Join(repoPath, "objects", "info", "alternates")
+	if !osutil.IsFile(alternates) {
+		return nil
+	}
+
+	if _, stderr, err := processed.ExecDir(gitTimeout(conf.Git.Timeout.GC), repoPath,
+		fmt.Sprintf("dissociateRepository (git repack -a -d): %s", repoPath),
+		"git", "repack", "-a", "-d"); err != nil {
+		return fmt.Errorf("git repack -a -d: %v - %s", err, stderr)
+	}
+	return os.Remove(alternates)
+}
+
+// SoftDeleteRepository moves the repository to trash, where it can be restored
+// until the retention period of the configuration expires. Forks of the repository
+// stop borrowing objects from it beforehand.
+func SoftDeleteRepository(repoLink string) (*DeletedRepo, error) {
+	deletedPath := repoPath(repoLink)
+	if !repoExists(deletedPath) {
+		return nil, errors.Errorf("repository %q does not exist", repoLink)
+	}
+
+	forks, err := GetForks(repoLink)
+	if err != nil {
+		return nil, fmt.Errorf("get forks: %v", err)
+	}
+	for _, fork := range forks {
+		if err = dissociateRepository(repoPath(fork)); err != nil {
+			return nil, fmt.Errorf("dissociate fork %q: %v", fork, err)
+		}
+	}
+	// The base repository may be deleted while this one is in trash.
+	if err = dissociateRepository(deletedPath); err != nil {
+		return nil, fmt.Errorf("dissociate repository: %v", err)
+	}
+
+	repoWorkingPool.CheckIn(repoLink)
+	defer repoWorkingPool.CheckOut(repoLink)
+	metaPath := RepoMetaPath(deletedPath)
+	repoWorkingPool.CheckIn(metaPath)
+	defer repoWorkingPool.CheckOut(metaPath)
+
+	now := time.Now()
+	deleted := &DeletedRepo{
+		ID:       gouuid.NewV4().String(),
+		RepoLink: repoLink,
+		Deleted:  now,
+	}
+	if conf.Repository.TrashRetention > 0 {
+		deleted.Expires = now.AddDate(0, 0, conf.Repository.TrashRetention)
+	}
+
+	if err = os.MkdirAll(trashEntryPath(deleted.ID), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err = writeDeletedRepo(deleted); err != nil {
+		_ = os.RemoveAll(trashEntryPath(deleted.ID))
+		return nil, err
+	}
+	if err = os.Rename(deletedPath, trashRepoPath(deleted.ID)); err != nil {
+		_ = os.RemoveAll(trashEntryPath(deleted.ID))
+		return nil, fmt.Errorf("move repository to trash: %v", err)
+	}
+
+	InvalidateRepoSize(repoLink)
+	RemoveIndex(repoLink)
+	// The local copy is recreated on demand.
+	if err = os.RemoveAll(LocalCopyPath(repoLink)); err != nil {
+		log.Error("Failed to remove local copy of deleted repository %q: %v", repoLink, err)
+	}
+	return deleted, nil
+}
+
+func writeDeletedRepo(deleted *DeletedRepo) error {
+	data, err := json.MarshalIndent(deleted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+	if err = os.WriteFile(trashInfoPath(deleted.ID), data, 0644); err != nil {
+		return fmt.Errorf("write file: %v", err)
+	}
+	return nil
+}
+
+func readDeletedRepo(id string) (*DeletedRepo, error) {
+	data, err := os.ReadFile(trashInfoPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("read file: %v", err)
+	}
+	deleted := new(DeletedRepo)
+	if err = json.Unmarshal(data, deleted); err != nil {
+		return nil, fmt.Errorf("unmarshal: %v", err)
+	}
+	return deleted, nil
+}
+
+// GetDeletedRepos returns repositories in trash, the most recently deleted first.
+// Only repositories of the owner are returned if the owner is not empty.
+func GetDeletedRepos(owner string) ([]*DeletedRepo, error) {
+	entries, err := os.ReadDir(TrashPath())
+	if os.IsNotExist(err) {
+		return []*DeletedRepo{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	repos := make([]*DeletedRepo, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		deleted, err := readDeletedRepo(e.Name())
+		if err != nil {
+			log.Error("Failed to read deleted repository %q: %v", e.Name(), err)
+			continue
+		}
+		if owner != "" && !strings.HasPrefix(deleted.RepoLink, owner+"/") {
+			continue
+		}
+		repos = append(repos, deleted)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Deleted.After(repos[j].Deleted)
+	})
+	return repos, nil
+}
+
+// RestoreRepository moves the repository by given trash ID back to where it was
+// deleted from.
+func RestoreRepository(id string) (*DeletedRepo, error) {
+	if strings.ContainsAny(id, `/\.`) {
+		return nil, errors.Errorf("invalid trash ID %q", id)
+	}
+	deleted, err := readDeletedRepo(id)
+	if err != nil {
+		return nil, errors.Errorf("deleted repository %q does not exist", id)
+	}
+
+	repoWorkingPool.CheckIn(deleted.RepoLink)
+	defer repoWorkingPool.CheckOut(deleted.RepoLink)
+
+	restoredPath := repoPath(deleted.RepoLink)
+	if osutil.IsExist(restoredPath) {
+		return nil, errors.Errorf("repository %q already exists", deleted.RepoLink)
+	}
+	if err = os.MkdirAll(filepath.Dir(restoredPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(trashRepoPath(id), restoredPath); err != nil {
+		return nil, fmt.Errorf("move repository out of trash: %v", err)
+	}
+	if err = os.RemoveAll(trashEntryPath(id)); err != nil {
+		log.Error("Failed to remove trash entry %q: %v", id, err)
+	}
+	go AddIndexing(deleted.RepoLink)
+	return deleted, nil
+}
+
+// PurgeTrash permanently removes repositories in trash and deleted branches that
+// have expired.
+func PurgeTrash() {
+	if taskStatusTable.IsRunning(taskPurgeTrash) {
+		return
+	}
+	taskStatusTable.Start(taskPurgeTrash)
+	defer taskStatusTable.Stop(taskPurgeTrash)
+
+	log.Trace("Doing: PurgeTrash")
+
+	repos, err := GetDeletedRepos("")
+	if err != nil {
+		log.Error("PurgeTrash: list deleted repositories: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, r := range repos {
+		if r.Expires.IsZero() || r.Expires.After(now) {
+			continue
+		}
+		if err = os.RemoveAll(trashEntryPath(r.ID)); err != nil {
+			log.Error("PurgeTrash: remove %q [repo: %s]: %v", r.ID, r.RepoLink, err)
+			continue
+		}
+		log.Trace("PurgeTrash: purged %q [repo: %s]", r.ID, r.RepoLink)
+	}
+
+	all, err := getAllRepos()
+	if err != nil {
+		log.Error("PurgeTrash: list repositories: %v", err)
+		return
+	}
+	for _, r := range all {
+		repoLink := strings.TrimSuffix(r, ".git")
+		if err = PurgeDeletedBranches(repoLink); err != nil {
+			log.Error("PurgeTrash: purge deleted branches [repo: %s]: %v", repoLink, err)
+		}
+	}
+}
+
+// InitPurgeTrash starts background purging of expired repositories in trash and
+// expired deleted branches.
+func InitPurgeTrash() {
+	go func() {
+		for {
+			PurgeTrash()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func ListDeletedRepos(c *context.Context) {
+	repos, err := GetDeletedRepos(c.Query("code"))
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	c.JSON(200, _type.SuccessResult(repos))
+}
+
+func RestoreRepoPost(c *context.Context) {
+	deleted, err := RestoreRepository(c.Params(":id"))
+	if err != nil {
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+	owner, name := path.Split(deleted.RepoLink)
+	c.JSON(200, _type.SuccessResult(repoutil.CloneLink{
+		HTTPS: repoutil.HTTPSCloneURL(strings.TrimSuffix(owner, "/"), name),
+	}))
+}
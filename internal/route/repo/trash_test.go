@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git-server/internal/conf"
+)
+
+func TestSoftDeleteRepository(t *testing.T) {
+	setupTestRoot(t)
+	oldServer := conf.Server
+	conf.Server.AppDataPath = t.TempDir()
+	defer func() { conf.Server = oldServer }()
+
+	newTestRepo(t, "alice/repo")
+	require.NoError(t, ForkRepository("alice/repo", "bob/repo"))
+
+	conf.Repository.TrashRetention = 1
+	deleted, err := SoftDeleteRepository("alice/repo")
+	require.NoError(t, err)
+	assert.False(t, repoExists(repoPath("alice/repo")))
+	assert.False(t, deleted.Expires.IsZero())
+
+	// The fork keeps working without the base repository.
+	runGit(t, repoPath("bob/repo"), "fsck")
+
+	repos, err := GetDeletedRepos("alice")
+	require.NoError(t, err)
+	require.Len(t, repos, 1)
+	assert.Equal(t, "alice/repo", repos[0].RepoLink)
+	repos, err = GetDeletedRepos("bob")
+	require.NoError(t, err)
+	assert.Empty(t, repos)
+
+	restored, err := RestoreRepository(deleted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice/repo", restored.RepoLink)
+	assert.True(t, repoExists(repoPath("alice/repo")))
+	runGit(t, repoPath("alice/repo"), "fsck")
+	_, err = RestoreRepository(deleted.ID)
+	assert.Error(t, err)
+
+	// Expired repositories are purged, others are kept.
+	expired, err := SoftDeleteRepository("alice/repo")
+	require.NoError(t, err)
+	conf.Repository.TrashRetention = 0
+	kept, err := SoftDeleteRepository("bob/repo")
+	require.NoError(t, err)
+	assert.True(t, kept.Expires.IsZero())
+
+	expired.Expires = time.Now().Add(-time.Minute)
+	require.NoError(t, writeDeletedRepo(expired))
+	PurgeTrash()
+	repos, err = GetDeletedRepos("")
+	require.NoError(t, err)
+	require.Len(t, repos, 1)
+	assert.Equal(t, kept.ID, repos[0].ID)
+}
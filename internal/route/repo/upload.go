@@ -42,6 +42,15 @@ func UploadFileToServer(c *context.Context) {
 	}
 	defer file.Close()
 
+	if err = CheckQuota(c.Repo.RepoLink, header.Size); err != nil {
+		if IsErrQuotaExceeded(err) {
+			c.JSON(403, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
+
 	buf := make([]byte, 1024)
 	n, _ := file.Read(buf)
 	if n > 0 {
@@ -191,6 +200,20 @@ func UploadFilePost(c *context.Context, f form.UploadRepoFile) {
 		message += "\n\n" + f.CommitMessage
 	}
 	uploads := process(f)
+	var size int64
+	for _, upload := range uploads {
+		if fi, err := os.Stat(upload.LocalPath()); err == nil {
+			size += fi.Size()
+		}
+	}
+	if err := CheckQuota(c.Repo.RepoLink, size); err != nil {
+		if IsErrQuotaExceeded(err) {
+			c.JSON(403, _type.FaildResult(err))
+			return
+		}
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
 	if err := UploadRepoFiles(UploadRepoFileOptions{
 		LastCommitID: c.Repo.CommitID,
 		OldBranch:    oldBranchName,
@@ -46,16 +46,24 @@ func renderDirectory(c *context.Context, treeLink string) {
 		}
 	}
 	c.Data["LatestCommit"] = latestCommit
+	readme, err := GetReadme(entries, c.Repo.TreePath, rawFileURL(c.Repo.GitRepo.Path(), c.Repo.CommitID, ""))
+	if err != nil {
+		//get readme
+		c.JSON(500, _type.FaildResult(err))
+		return
+	}
 	res := struct {
 		DefaultBranch   string
 		Branchs         []string
 		LatestCommit    map[string]interface{}
 		EntryCommitInfo []_type.EntryCommitInfo
+		Readme          *Readme `json:",omitempty"`
 	}{
 		DefaultBranch:   c.Repo.BranchName,
 		Branchs:         c.Data["Branches"].([]string),
 		LatestCommit:    _type.ProduceLastCommit(latestCommit),
 		EntryCommitInfo: _type.ProduceEntryCommitInfo(data),
+		Readme:          readme,
 	}
 	c.JSON(200, _type.SuccessResult(res))
 
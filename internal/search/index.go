@@ -0,0 +1,263 @@
+package search
+
+import (
+	"encoding/gob"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// File is a text file to be indexed.
+type File struct {
+	Path string
+	// The ID of the blob to read the content from when searching.
+	BlobID string
+	// The content is only used to build the index and never kept by it.
+	Content string
+}
+
+// Version is the format version of indexes, those of other versions need to
+// be rebuilt.
+const Version = 2
+
+// ErrOutdated is returned when loading an index of another format version.
+var ErrOutdated = errors.New("index is outdated")
+
+// ErrTooManyFiles is returned when a search needs to read more files than the
+// max number of files of options.
+var ErrTooManyFiles = errors.New("too many files to search")
+
+// Index is a trigram index of files of a repository at a commit.
+type Index struct {
+	Version  int
+	CommitID string
+	Files    []File // Without content
+	// Sorted indexes of files in Files containing the trigram, by lowercase trigram.
+	Trigrams map[string][]int
+}
+
+// NewIndex builds the index of given files.
+func NewIndex(commitID string, files []File) *Index {
+	// Keep files ordered by path so results of searches are too.
+	files = append([]File(nil), files...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	idx := &Index{
+		Version:  Version,
+		CommitID: commitID,
+		Files:    files,
+		Trigrams: make(map[string][]int),
+	}
+	for i := range files {
+		for t := range trigrams(strings.ToLower(files[i].Content)) {
+			idx.Trigrams[t] = append(idx.Trigrams[t], i)
+		}
+		files[i].Content = ""
+	}
+	return idx
+}
+
+// trigrams returns the set of all three-byte substrings of s.
+func trigrams(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for i := 0; i+3 <= len(s); i++ {
+		set[s[i:i+3]] = struct{}{}
+	}
+	return set
+}
+
+// Load reads the index from the file in given path.
+func Load(p string) (*Index, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	idx := new(Index)
+	if err = gob.NewDecoder(f).Decode(idx); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	} else if idx.Version != Version {
+		return nil, ErrOutdated
+	}
+	return idx, nil
+}
+
+// Save writes the index to the file in given path, the file is replaced at once
+// so readers never see a partial index.
+func (idx *Index) Save(p string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	err = gob.NewEncoder(f).Encode(idx)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Options contains conditions of a search.
+type Options struct {
+	// The text to search for case-insensitively, or a regular expression if IsRegexp.
+	Query    string
+	IsRegexp bool
+	// A glob pattern (e.g. "*.go" or "cmd/*/main.go") that paths of files must
+	// match, patterns without "/" are matched against base names.
+	Path string
+	// Max number of matches to return, zero means unlimited.
+	MaxResults int
+	// Max number of files whose contents may be read, zero means unlimited.
+	MaxFiles int
+}
+
+// Match is a line of a file that matches the search.
+type Match struct {
+	Path    string
+	Line    int // 1-based
+	Content string
+}
+
+// ContentReader returns the content of an indexed file.
+type ContentReader func(f File) (string, error)
+
+// Search returns lines of indexed files that match the options, ordered by path
+// and line number. Contents of files that may match are read by read.
+func (idx *Index) Search(opts Options, read ContentReader) ([]Match, error) {
+	if opts.Query == "" {
+		return nil, errors.New("query is required")
+	}
+
+	var (
+		match   func(line string) bool
+		literal string // A substring every matched line must contain, in lowercase
+	)
+	if opts.IsRegexp {
+		re, err := regexp.Compile(opts.Query)
+		if err != nil {
+			return nil, errors.Wrap(err, "compile regexp")
+		}
+		match = re.MatchString
+		if prefix, _ := re.LiteralPrefix(); prefix != "" {
+			literal = strings.ToLower(prefix)
+		}
+	} else {
+		literal = strings.ToLower(opts.Query)
+		match = func(line string) bool {
+			return strings.Contains(strings.ToLower(line), literal)
+		}
+	}
+	if opts.Path != "" {
+		if _, err := path.Match(opts.Path, ""); err != nil {
+			return nil, errors.Wrap(err, "bad path pattern")
+		}
+	}
+
+	var files []File
+	for _, i := range idx.candidates(literal) {
+		if matchPath(opts.Path, idx.Files[i].Path) {
+			files = append(files, idx.Files[i])
+		}
+	}
+	if opts.MaxFiles > 0 && len(files) > opts.MaxFiles {
+		return nil, ErrTooManyFiles
+	}
+
+	var matches []Match
+	for _, f := range files {
+		content, err := read(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %q", f.Path)
+		}
+		for n, line := range strings.Split(content, "\n") {
+			if !match(line) {
+				continue
+			}
+			matches = append(matches, Match{
+				Path:    f.Path,
+				Line:    n + 1,
+				Content: line,
+			})
+			if opts.MaxResults > 0 && len(matches) >= opts.MaxResults {
+				return matches, nil
+			}
+		}
+	}
+	return matches, nil
+}
+
+// candidates returns sorted indexes of files that may contain the lowercase
+// literal, which are all files if the literal is too short to use the index.
+func (idx *Index) candidates(literal string) []int {
+	var result []int
+	if len(literal) < 3 {
+		result = make([]int, len(idx.Files))
+		for i := range result {
+			result[i] = i
+		}
+	} else {
+		first := true
+		for t := range trigrams(literal) {
+			if first {
+				result = idx.Trigrams[t]
+				first = false
+			} else {
+				result = intersect(result, idx.Trigrams[t])
+			}
+			if len(result) == 0 {
+				return nil
+			}
+		}
+	}
+	return result
+}
+
+// intersect returns elements of both sorted slices.
+func intersect(a, b []int) []int {
+	result := make([]int, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// matchPath reports whether the path matches the glob pattern, an empty pattern
+// matches all paths.
+func matchPath(pattern, p string) bool {
+	if pattern == "" {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		p = path.Base(p)
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
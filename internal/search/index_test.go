@@ -0,0 +1,126 @@
+package search
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// contentReader returns a reader of contents of given files by their blob IDs.
+func contentReader(files []File) ContentReader {
+	contents := make(map[string]string)
+	for _, f := range files {
+		contents[f.BlobID] = f.Content
+	}
+	return func(f File) (string, error) {
+		content, ok := contents[f.BlobID]
+		if !ok {
+			return "", errors.New("blob does not exist")
+		}
+		return content, nil
+	}
+}
+
+func TestIndex_Search(t *testing.T) {
+	files := []File{
+		{Path: "main.go", BlobID: "1", Content: "package main\n\nfunc main() {\n\tprintln(\"Hello\")\n}\n"},
+		{Path: "cmd/web/web.go", BlobID: "2", Content: "package web\n\n// Hello serves the web.\nfunc Hello() {}\n"},
+		{Path: "README.md", BlobID: "3", Content: "# Hello world\n"},
+	}
+	idx := NewIndex("abc", files)
+	read := contentReader(files)
+	for _, f := range idx.Files {
+		assert.Empty(t, f.Content, "contents are not kept")
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []Match
+	}{
+		{
+			name: "case-insensitive literal",
+			opts: Options{Query: "hello"},
+			want: []Match{
+				{Path: "README.md", Line: 1, Content: "# Hello world"},
+				{Path: "cmd/web/web.go", Line: 3, Content: "// Hello serves the web."},
+				{Path: "cmd/web/web.go", Line: 4, Content: "func Hello() {}"},
+				{Path: "main.go", Line: 4, Content: "\tprintln(\"Hello\")"},
+			},
+		},
+		{
+			name: "regexp",
+			opts: Options{Query: `^func \w+\(\)`, IsRegexp: true},
+			want: []Match{
+				{Path: "cmd/web/web.go", Line: 4, Content: "func Hello() {}"},
+				{Path: "main.go", Line: 3, Content: "func main() {"},
+			},
+		},
+		{
+			name: "base name pattern",
+			opts: Options{Query: "hello", Path: "*.go"},
+			want: []Match{
+				{Path: "cmd/web/web.go", Line: 3, Content: "// Hello serves the web."},
+				{Path: "cmd/web/web.go", Line: 4, Content: "func Hello() {}"},
+				{Path: "main.go", Line: 4, Content: "\tprintln(\"Hello\")"},
+			},
+		},
+		{
+			name: "full path pattern",
+			opts: Options{Query: "package", Path: "cmd/*/*.go"},
+			want: []Match{
+				{Path: "cmd/web/web.go", Line: 1, Content: "package web"},
+			},
+		},
+		{
+			name: "short query",
+			opts: Options{Query: "()", MaxResults: 1},
+			want: []Match{
+				{Path: "cmd/web/web.go", Line: 4, Content: "func Hello() {}"},
+			},
+		},
+		{
+			name: "no match",
+			opts: Options{Query: "goodbye"},
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := idx.Search(test.opts, read)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	_, err := idx.Search(Options{Query: "("}, read)
+	assert.NoError(t, err)
+	_, err = idx.Search(Options{Query: "(", IsRegexp: true}, read)
+	assert.Error(t, err)
+	_, err = idx.Search(Options{Query: "hello"}, contentReader(nil))
+	assert.Error(t, err)
+
+	// Files are counted before any of them is read.
+	_, err = idx.Search(Options{Query: "()", MaxFiles: 2}, contentReader(nil))
+	assert.Equal(t, ErrTooManyFiles, err)
+	_, err = idx.Search(Options{Query: "()", Path: "*.go", MaxFiles: 2}, read)
+	assert.NoError(t, err)
+}
+
+func TestIndex_SaveLoad(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "owner", "repo.idx")
+	idx := NewIndex("abc", []File{{Path: "a.txt", BlobID: "1", Content: "some text"}})
+	require.NoError(t, idx.Save(p))
+
+	got, err := Load(p)
+	require.NoError(t, err)
+	assert.Equal(t, idx, got)
+
+	idx.Version = 1
+	require.NoError(t, idx.Save(p))
+	_, err = Load(p)
+	assert.Equal(t, ErrOutdated, err)
+}
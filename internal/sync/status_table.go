@@ -32,6 +32,19 @@ func (p *StatusTable) Start(name string) {
 	p.pool[name] = true
 }
 
+// TryStart sets value of given name to true in the pool and returns true if it
+// was false, otherwise it returns false and leaves the value as is.
+func (p *StatusTable) TryStart(name string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.pool[name] {
+		return false
+	}
+	p.pool[name] = true
+	return true
+}
+
 // Stop sets value of given name to false in the pool.
 func (p *StatusTable) Stop(name string) {
 	p.Lock()
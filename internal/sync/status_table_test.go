@@ -0,0 +1,31 @@
+package sync
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusTable_TryStart(t *testing.T) {
+	table := NewStatusTable()
+
+	var started int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if table.TryStart("task") {
+				atomic.AddInt32(&started, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), started)
+	assert.True(t, table.IsRunning("task"))
+
+	table.Stop("task")
+	assert.True(t, table.TryStart("task"))
+}
@@ -18,6 +18,6 @@ func main() {
 		cmd.Web,
 	}
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal("Failed to start application: %v", err)
+		log.Fatalf("Failed to start application: %v", err)
 	}
 }